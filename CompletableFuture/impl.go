@@ -19,10 +19,11 @@ package CompletableFuture
 import (
 	"github.com/xfali/completable"
 	"github.com/xfali/executor"
+	"time"
 )
 
-func CompletedFuture(value interface{}) (retCf completable.CompletionStage) {
-	return completable.CompletedFuture(value)
+func CompletedFuture(value interface{}, opts ...completable.Option) (retCf completable.CompletionStage) {
+	return completable.CompletedFuture(value, opts...)
 }
 
 func SupplyAsync(f interface{}, executor ...executor.Executor) (retCf completable.CompletionStage) {
@@ -40,3 +41,48 @@ func AllOf(cfs ...completable.CompletionStage) (retCf completable.CompletionStag
 func AnyOf(cfs ...completable.CompletionStage) (retCf completable.CompletionStage) {
 	return completable.AnyOf(cfs...)
 }
+
+// 返回已经正常完成的CompletionStage，该CompletionStage不能被Complete
+func CompletedStage(value interface{}) (retCf completable.CompletionStage) {
+	return MinimalCompletionStage(completable.CompletedFuture(value))
+}
+
+// 返回已经异常结束的CompletionStage
+// Param：cause panic参数
+func FailedFuture(cause interface{}) (retCf completable.CompletionStage) {
+	retCf, resolver := completable.NewPromise()
+	resolver.Reject(cause)
+	return retCf
+}
+
+// 返回已经异常结束的CompletionStage，该CompletionStage不能被Complete
+// Param：cause panic参数
+func FailedStage(cause interface{}) (retCf completable.CompletionStage) {
+	return MinimalCompletionStage(FailedFuture(cause))
+}
+
+// 延迟delay后异步执行f
+// Param：f func() TYPE
+// Param：delay 延迟时间
+// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+func SupplyAsyncDelayed(f interface{}, delay time.Duration, executor ...executor.Executor) (retCf completable.CompletionStage) {
+	return completable.SupplyAsync(f, DelayedExecutor(delay, executor...))
+}
+
+// 返回延迟delay后再将任务提交到executor的Executor，延迟使用默认引擎的时钟
+// 其他引擎请使用completable.Engine.DelayedExecutor
+// Param：delay 延迟时间
+// Param：Executor: 实际执行的协程池，如果不填则使用默认引擎的协程池
+func DelayedExecutor(delay time.Duration, executor ...executor.Executor) executor.Executor {
+	return completable.DefaultEngine().DelayedExecutor(delay, executor...)
+}
+
+// 返回只读的CompletionStage，Complete及CompleteExceptionally返回*completable.ReadOnlyError
+func MinimalCompletionStage(cs completable.CompletionStage) (retCf completable.CompletionStage) {
+	return completable.ReadOnly(cs)
+}
+
+// 复制CompletionStage，新阶段与原阶段的结果相同，可以独立完成
+func Copy(cs completable.CompletionStage) (retCf completable.CompletionStage) {
+	return completable.Copy(cs)
+}
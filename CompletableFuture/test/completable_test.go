@@ -0,0 +1,235 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/CompletableFuture"
+	"testing"
+	"time"
+)
+
+func TestCompletedStage(t *testing.T) {
+	t.Run("normal", func(t *testing.T) {
+		cf := CompletableFuture.CompletedStage("Hello world").ThenAccept(func(s string) {
+			if s != "Hello world" {
+				t.Fatal("not match")
+			}
+		})
+		cf.Get(nil)
+		if !cf.IsDone() {
+			t.Fatal("Must be done")
+		}
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		cf := CompletableFuture.CompletedStage("Hello world")
+		if err := cf.Complete("complete"); !isReadOnlyError(err) {
+			t.Fatal("expect ReadOnlyError")
+		}
+		if err := cf.CompleteExceptionally("error"); !isReadOnlyError(err) {
+			t.Fatal("expect ReadOnlyError")
+		}
+		ret := ""
+		cf.Get(&ret)
+		if ret != "Hello world" {
+			t.Fatal("not match")
+		}
+	})
+}
+
+func TestFailedFuture(t *testing.T) {
+	t.Run("exceptionally", func(t *testing.T) {
+		ret := 0
+		cf := CompletableFuture.FailedFuture("error").Exceptionally(func(o interface{}) int {
+			if o.(string) != "error" {
+				t.Fatal("not match")
+			}
+			return 1
+		})
+		cf.Get(&ret)
+		if ret != 1 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Log("Panic!", r)
+				if r.(string) != "error" {
+					t.Fatal("not match")
+				}
+			} else {
+				t.Fatal("must panic")
+			}
+		}()
+		CompletableFuture.FailedFuture("error").Get(nil)
+	})
+}
+
+func TestFailedStage(t *testing.T) {
+	ret := ""
+	cf := CompletableFuture.FailedStage("error")
+	if err := cf.Complete("complete"); !isReadOnlyError(err) {
+		t.Fatal("expect ReadOnlyError")
+	}
+	cf.Handle(func(o interface{}, p interface{}) string {
+		return p.(string)
+	}).Get(&ret)
+	if ret != "error" {
+		t.Fatal("not match")
+	}
+}
+
+func TestSupplyAsyncDelayed(t *testing.T) {
+	now := time.Now()
+	ret := 0
+	cf := CompletableFuture.SupplyAsyncDelayed(func() int {
+		return 1
+	}, 500*time.Millisecond)
+	cf.Get(&ret)
+	useTime := time.Since(now)
+	if ret != 1 {
+		t.Fatal("not match")
+	}
+	if useTime < 500*time.Millisecond || useTime > 600*time.Millisecond {
+		t.Fatal("must 500 millisecond", useTime)
+	}
+}
+
+func TestDelayedExecutor(t *testing.T) {
+	now := time.Now()
+	exec := CompletableFuture.DelayedExecutor(500 * time.Millisecond)
+	ret := ""
+	cf := CompletableFuture.CompletedFuture("Hello").ThenApplyAsync(func(s string) string {
+		return s + " world"
+	}, exec)
+	cf.Get(&ret)
+	useTime := time.Since(now)
+	if ret != "Hello world" {
+		t.Fatal("not match")
+	}
+	if useTime < 500*time.Millisecond || useTime > 600*time.Millisecond {
+		t.Fatal("must 500 millisecond", useTime)
+	}
+}
+
+func TestDelayedExecutorInherit(t *testing.T) {
+	now := time.Now()
+	ret := 0
+	// 后续阶段不继承延迟
+	cf := CompletableFuture.SupplyAsyncDelayed(func() int {
+		return 1
+	}, 200*time.Millisecond).ThenApplyAsync(func(i int) int {
+		return i + 1
+	}).ThenApplyAsync(func(i int) int {
+		return i + 1
+	})
+	cf.Get(&ret)
+	useTime := time.Since(now)
+	if ret != 3 {
+		t.Fatal("not match")
+	}
+	if useTime > 300*time.Millisecond {
+		t.Fatal("must 200 millisecond", useTime)
+	}
+}
+
+func TestDelayedExecutorStop(t *testing.T) {
+	exec := CompletableFuture.DelayedExecutor(time.Hour)
+	cf := completable.SupplyAsync(func() int {
+		return 1
+	}, exec, completable.WithPanicPolicy(completable.PanicAsError))
+	exec.Stop()
+	err := cf.Get(nil, time.Second)
+	if !errors.Is(err, completable.ErrRejected) || !errors.Is(err, completable.ErrPoolStopped) {
+		t.Fatal("expect ErrPoolStopped but get ", err)
+	}
+	if err := exec.Run(func() {}); !errors.Is(err, completable.ErrPoolStopped) {
+		t.Fatal("expect ErrPoolStopped but get ", err)
+	}
+}
+
+func TestMinimalCompletionStage(t *testing.T) {
+	t.Run("normal", func(t *testing.T) {
+		ret := ""
+		origin := CompletableFuture.SupplyAsync(func() string {
+			time.Sleep(100 * time.Millisecond)
+			return "Hello"
+		})
+		cf := CompletableFuture.MinimalCompletionStage(origin)
+		if err := cf.Complete("complete"); !isReadOnlyError(err) {
+			t.Fatal("expect ReadOnlyError")
+		}
+		cf.ThenApply(func(s string) string {
+			return s + " world"
+		}).Get(&ret)
+		if ret != "Hello world" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("combine", func(t *testing.T) {
+		ret := ""
+		cf := CompletableFuture.CompletedFuture("Hello").ThenCombine(
+			CompletableFuture.CompletedStage("world"), func(s1, s2 string) string {
+				return s1 + " " + s2
+			})
+		cf.Get(&ret)
+		if ret != "Hello world" {
+			t.Fatal("not match")
+		}
+	})
+}
+
+func TestCopy(t *testing.T) {
+	t.Run("normal", func(t *testing.T) {
+		origin := CompletableFuture.SupplyAsync(func() string {
+			time.Sleep(100 * time.Millisecond)
+			return "Hello"
+		})
+		cf := CompletableFuture.Copy(origin)
+		ret1, ret2 := "", ""
+		cf.Get(&ret1)
+		origin.Get(&ret2)
+		if ret1 != "Hello" || ret2 != "Hello" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		origin := CompletableFuture.SupplyAsync(func() string {
+			time.Sleep(500 * time.Millisecond)
+			return "Hello"
+		})
+		cf := CompletableFuture.Copy(origin)
+		cf.Complete("complete")
+		ret1, ret2 := "", ""
+		cf.Get(&ret1)
+		origin.Get(&ret2)
+		if ret1 != "complete" || ret2 != "Hello" {
+			t.Fatal("not match", ret1, ret2)
+		}
+	})
+}
+
+func isReadOnlyError(err error) bool {
+	var readOnlyErr *completable.ReadOnlyError
+	return errors.As(err, &readOnlyErr)
+}
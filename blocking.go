@@ -0,0 +1,313 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var ErrWorkerBlocked = errors.New("Blocking wait in executor worker. ")
+
+// 工作协程阻塞等待其他阶段且协程池不允许补偿时的诊断错误，errors.Is(err, ErrWorkerBlocked)为true
+type BlockingError struct {
+	// 工作协程所属的协程池
+	Executor string
+	// 协程池的工作协程数
+	Workers int
+	// 包括当前协程在内，正在阻塞等待的工作协程数
+	Blocked int64
+	// 阻塞等待的协程堆栈
+	Stack []byte
+}
+
+func (e *BlockingError) Error() string {
+	return fmt.Sprintf("Blocking wait in executor worker without compensation (%s, %d/%d workers blocked), may deadlock. ",
+		e.Executor, e.Blocked, e.Workers)
+}
+
+func (e *BlockingError) Is(target error) bool {
+	return target == ErrWorkerBlocked
+}
+
+// 工作协程中的阶段阻塞等待其他阶段时，协程池的补偿方式（类似Java的ManagedBlocker）
+// 以下调用在工作协程中等待尚未结束的阶段时视为阻塞：
+// Get、同步的Then*方法、AllOf、AnyOf，以及*Async阶段的任务等待上一阶段（如ThenCombineAsync等待两个阶段）
+// *Async阶段的任务等待上一阶段是内部等待，由执行任务的协程池标记，BlockingFail时按BlockingSpawn补偿
+// 其他等待只有等待的阶段以任务的context创建（参考WithContext）时才能识别，否则视为普通等待
+type BlockingMode int
+
+const (
+	// 创建临时工作协程执行队列中的任务，等待结束后临时工作协程退出
+	// 临时工作协程数达到协程池的上限时按BlockingRunInline补偿
+	BlockingSpawn BlockingMode = iota
+	// 在等待的工作协程中执行队列中的任务，直到等待结束
+	BlockingRunInline
+	// 不补偿，直接等待（工作协程全部阻塞时可能死锁）
+	BlockingWait
+	// 不补偿，Get等用户发起的等待立即以*BlockingError失败，用于诊断
+	BlockingFail
+)
+
+func (m BlockingMode) String() string {
+	switch m {
+	case BlockingSpawn:
+		return "Spawn"
+	case BlockingRunInline:
+		return "RunInline"
+	case BlockingWait:
+		return "Wait"
+	case BlockingFail:
+		return "Fail"
+	default:
+		return "Unknown"
+	}
+}
+
+// 支持管理阻塞的协程池
+type managedPool interface {
+	blockingMode() BlockingMode
+	// 阻塞等待的工作协程数变化，返回变化后的数量
+	addBlocked(delta int64) int64
+	// 工作协程数
+	workerCount() int
+	// 等待并获取一个任务，stop关闭或协程池停止时返回false
+	takeTask(stop <-chan struct{}) (poolTask, bool)
+	// 执行任务，捕获panic
+	execute(t poolTask)
+	// 在新的协程中执行f，协程池停止时等待f结束；临时工作协程数已达上限时返回false
+	spawn(f func()) bool
+}
+
+// 执行任务的协程池及工作协程，协程池开始执行任务时设置，任务结束时清除
+// 阶段任务中等待上一阶段，以及以任务的context（参考WithContext）创建的阶段等待及提交任务时使用
+type taskOwner struct {
+	lock sync.Mutex
+	pool managedPool
+	// 执行任务的工作窃取协程池的工作协程，其他协程池或补偿协程执行时为nil
+	worker *stealWorker
+}
+
+type upstreamKey struct{}
+
+// 以任务的context创建的阶段，其context携带执行该任务的taskOwner
+type ownerKey struct{}
+
+func (o *taskOwner) begin(p managedPool, w *stealWorker) {
+	o.lock.Lock()
+	o.pool, o.worker = p, w
+	o.lock.Unlock()
+}
+
+func (o *taskOwner) end() {
+	o.lock.Lock()
+	o.pool, o.worker = nil, nil
+	o.lock.Unlock()
+}
+
+// 获得正在执行任务的协程池，任务未在协程池中执行时返回nil
+func (o *taskOwner) current() managedPool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.pool
+}
+
+// 获得正在执行任务的工作窃取协程池的工作协程，没有时返回nil
+func (o *taskOwner) currentWorker() *stealWorker {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.worker
+}
+
+// 获得阶段任务的taskOwner，ctx不是阶段任务的context时返回nil
+func stageOwner(ctx context.Context) *taskOwner {
+	if cb, ok := ctx.Value(rejectKey{}).(*taskCallbacks); ok {
+		return cb.owner
+	}
+	return nil
+}
+
+// 阶段任务中等待上一阶段时使用的context：ctx为等待使用的context（可以为nil），taskCtx为任务的context
+// 等待由执行任务的协程池补偿，不受BlockingFail限制
+func upstreamCtx(ctx, taskCtx context.Context) context.Context {
+	owner := stageOwner(taskCtx)
+	if owner == nil {
+		return ctx
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, upstreamKey{}, owner)
+}
+
+// 获得传给用户函数的任务context，以该context创建的阶段（WithContext）在任务中等待时由协程池补偿
+func ownerCtx(taskCtx context.Context) context.Context {
+	owner := stageOwner(taskCtx)
+	if owner == nil {
+		return taskCtx
+	}
+	return context.WithValue(taskCtx, ownerKey{}, owner)
+}
+
+// 获得等待所在的协程池，internal为true表示*Async阶段的任务等待上一阶段
+// 只识别context携带的taskOwner，任务已结束或不在协程池中执行时返回nil
+func blockingPool(ctx context.Context) (p managedPool, internal bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	if owner, ok := ctx.Value(upstreamKey{}).(*taskOwner); ok {
+		return owner.current(), true
+	}
+	if owner, ok := ctx.Value(ownerKey{}).(*taskOwner); ok {
+		return owner.current(), false
+	}
+	return nil, false
+}
+
+func noBlocking() {}
+
+// 即将阻塞等待dones时调用，ctx没有携带正在执行的任务时不做任何操作
+// all为true时等待所有dones关闭，否则等待任意一个关闭；ctx结束时同样结束等待
+// 返回等待结束后调用的函数；协程池不允许阻塞时返回*BlockingError
+// BlockingRunInline在返回前执行队列中的任务直到等待结束
+func beginBlocking(ctx context.Context, all bool, dones ...<-chan struct{}) (func(), error) {
+	p, internal := blockingPool(ctx)
+	if p == nil {
+		return noBlocking, nil
+	}
+	mode := p.blockingMode()
+	if internal && mode == BlockingFail {
+		mode = BlockingSpawn
+	}
+	if mode == BlockingWait {
+		return noBlocking, nil
+	}
+	blocked := p.addBlocked(1)
+	if mode == BlockingFail {
+		p.addBlocked(-1)
+		buf := make([]byte, 4096)
+		return noBlocking, &BlockingError{
+			Executor: fmt.Sprintf("%T", p),
+			Workers:  p.workerCount(),
+			Blocked:  blocked,
+			Stack:    buf[:runtime.Stack(buf, false)],
+		}
+	}
+
+	// 等待结束时关闭stop，通知补偿结束
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(stop)
+		var ctxDone <-chan struct{}
+		if ctx != nil {
+			ctxDone = ctx.Done()
+		}
+		waitDones(all, ctxDone, finished, dones...)
+	}()
+	end := func() {
+		close(finished)
+		p.addBlocked(-1)
+	}
+
+	if mode == BlockingSpawn && p.spawn(func() {
+		for {
+			t, ok := p.takeTask(stop)
+			if !ok {
+				return
+			}
+			p.execute(t)
+		}
+	}) {
+		return end, nil
+	}
+
+	// BlockingRunInline，或临时工作协程数已达上限
+	for {
+		t, ok := p.takeTask(stop)
+		if !ok {
+			break
+		}
+		p.execute(t)
+	}
+	return end, nil
+}
+
+// 等待dones全部（all为true）或任意一个关闭，ctxDone或finished关闭时立即返回
+func waitDones(all bool, ctxDone, finished <-chan struct{}, dones ...<-chan struct{}) {
+	if all {
+		for _, d := range dones {
+			select {
+			case <-d:
+			case <-ctxDone:
+				return
+			case <-finished:
+				return
+			}
+		}
+		return
+	}
+	cases := make([]reflect.SelectCase, 0, len(dones)+2)
+	for _, ch := range append([]<-chan struct{}{ctxDone, finished}, dones...) {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(ch),
+		})
+	}
+	reflect.Select(cases)
+}
+
+// 轮询获取任务直到stop关闭，用于没有可等待的队列channel的协程池
+func pollTask(stop <-chan struct{}, try func() (poolTask, bool)) (poolTask, bool) {
+	wait := 50 * time.Microsecond
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		if t, ok := try(); ok {
+			return t, true
+		}
+		select {
+		case <-stop:
+			return poolTask{}, false
+		case <-timer.C:
+		}
+		if wait < time.Millisecond {
+			wait *= 2
+		}
+		timer.Reset(wait)
+	}
+}
+
+// 等待被拒绝时阶段获得的结果
+func newBlockingPanic(err error) ValueOrError {
+	trace := []byte(nil)
+	if be, ok := err.(*BlockingError); ok {
+		trace = be.Stack
+	}
+	return vOrErr{
+		v: &panicMsg{
+			origin: err,
+			trace:  trace,
+		},
+		status: vOrErrPanic,
+	}
+}
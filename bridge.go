@@ -0,0 +1,113 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable/functools"
+)
+
+// 将任意CompletionStage转换为默认实现
+// 实现Joinable的阶段使用汇合后的阶段，其他实现由引擎e桥接，参考bridge
+func convert(e *Engine, detach <-chan struct{}, stage CompletionStage) *defaultCompletableFuture {
+	if stage == nil {
+		panic("CompletionStage is nil")
+	}
+	for {
+		if v, ok := stage.(*defaultCompletableFuture); ok {
+			return v
+		}
+		joinable, ok := stage.(Joinable)
+		if !ok {
+			return bridge(e, detach, stage)
+		}
+		joined := joinable.JoinCompletionStage(context.Background())
+		if joined == nil || joined == stage {
+			return bridge(e, detach, stage)
+		}
+		stage = joined
+	}
+}
+
+// 转换参数阶段，外部实现使用阶段所属的引擎桥接
+// 后续阶段被取消时按取消模式取消桥接阶段，从而停止等待
+func (cf *defaultCompletableFuture) convertStage(stage CompletionStage) *defaultCompletableFuture {
+	return convert(cf.engine, nil, stage)
+}
+
+// 桥接外部实现的CompletionStage
+// 1、在单独的协程中等待外部阶段Done，不占用协程池的工作协程（有界协程池中等待会耗尽工作协程导致死锁），
+// 等待登记到引擎e，Shutdown等待其结束
+// 2、外部阶段完成时复制其结果，取消桥接阶段时同时取消外部阶段并停止等待
+// 3、detach关闭时停止等待，桥接阶段被取消但不取消外部阶段，detach可以为nil
+func bridge(e *Engine, detach <-chan struct{}, stage CompletionStage) *defaultCompletableFuture {
+	vh := NewAsyncHandler(nil)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	ret := newCfWithCancel(e, ctx, cancel, vh)
+	if !e.acquire() {
+		vh.SetPanic(ErrEngineShutdown)
+		return ret
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		stage.CancelWithCause(context.Cause(ctx))
+	})
+	go func() {
+		defer e.release()
+		defer stop()
+		select {
+		case <-stage.Done():
+		case <-ctx.Done():
+			vh.setCancel(newCancellationError(context.Cause(ctx), -1))
+			return
+		case <-detach:
+			vh.setCancel(newCancellationError(nil, -1))
+			return
+		}
+		ve, ok := stage.Result()
+		if !ok || ve == nil {
+			vh.SetPanic(errors.New("CompletionStage done without result. "))
+			return
+		}
+		setForeignValue(vh, ve)
+	}()
+	return ret
+}
+
+func setForeignValue(vh *defaultValueHandler, ve ValueOrError) {
+	switch {
+	case ve.HaveValue():
+		v := ve.GetValue()
+		if !v.IsValid() {
+			v = functools.NilValue
+		}
+		if err := vh.SetValue(v); err != nil {
+			vh.SetPanic(err)
+		}
+	case ve.HaveError():
+		vh.SetError(ve.GetError())
+	case ve.HavePanic():
+		vh.SetPanic(ve.GetPanic())
+	default:
+		err := ve.GetCancellation()
+		if err == nil {
+			err = newCancellationError(nil, -1)
+		}
+		vh.setCancel(err)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// 阶段的取消模式
+type CancelMode int32
+
+const (
+	// 取消阶段时只取消该阶段及其后续阶段
+	// 上一阶段的所有后续阶段都被取消时才取消上一阶段（引用计数）
+	CancelBranch CancelMode = iota
+	// 取消阶段时取消整个阶段链，包括上游阶段及其所有分支
+	CancelChain
+)
+
+// 设置默认引擎的取消模式，只影响之后创建的阶段，默认为CancelBranch
+// 其他引擎请使用Engine.SetCancelMode或EngineCancelMode
+func SetCancelMode(mode CancelMode) {
+	defaultEngine.SetCancelMode(mode)
+}
+
+// 创建依赖parents的阶段，阶段的context、继承的协程池及优先级来自第一个上一阶段
+func newDependent(vh *defaultValueHandler, parents ...*defaultCompletableFuture) *defaultCompletableFuture {
+	ret := newCancelDependent(vh, parents...)
+	ret.exec = parents[0].exec
+	ret.priority = parents[0].priority
+	return ret
+}
+
+func newCancelDependent(vh *defaultValueHandler, parents ...*defaultCompletableFuture) *defaultCompletableFuture {
+	if parents[0].cancelMode == CancelChain {
+		ctx, cancel := context.WithCancelCause(parents[0].ctx)
+		return newCfWithCancel(parents[0].engine, ctx, func(cause error) {
+			cancel(cause)
+			for _, p := range parents {
+				p.cancelFunc(cause)
+			}
+		}, vh)
+	}
+
+	ctx, cancel := context.WithCancelCause(parents[0].ctx)
+	for _, p := range parents {
+		p.retain()
+	}
+	once := sync.Once{}
+	return newCfWithCancel(parents[0].engine, ctx, func(cause error) {
+		cancel(cause)
+		// 阶段确实被取消时才释放上一阶段，已完成的阶段取消失败不影响上游
+		if vh.getStatus() != valueHandlerUnknown {
+			return
+		}
+		once.Do(func() {
+			for _, p := range parents {
+				p.release(cause)
+			}
+		})
+	}, vh)
+}
+
+// 增加未被取消的后续阶段计数
+func (cf *defaultCompletableFuture) retain() {
+	atomic.AddInt32(&cf.refs, 1)
+}
+
+// 后续阶段被取消，所有后续阶段都被取消时取消该阶段
+func (cf *defaultCompletableFuture) release(cause error) {
+	if atomic.AddInt32(&cf.refs, -1) > 0 {
+		return
+	}
+	// 只读视图不能取消，不影响原阶段
+	if cf.readOnly {
+		return
+	}
+	cf.handler().setCancel(newCancellationError(cause, -1))
+	cf.cancelFunc(cause)
+}
@@ -22,4 +22,15 @@ type Completable interface {
 
 	// 发送panic，异常结束
 	CompleteExceptionally(v interface{}) error
+
+	// 强制设置阶段的值，无论阶段是否已经结束
+	// 已经读取原结果的后续阶段不受影响，之后读取结果的Get、Result及后续阶段获得新值
+	ObtrudeValue(v interface{}) error
+
+	// 强制设置阶段的panic，无论阶段是否已经结束
+	// 已经读取原结果的后续阶段不受影响，之后读取结果的Get、Result及后续阶段获得该panic
+	ObtrudeException(cause interface{}) error
 }
+
+// Completable的别名，用于只需要完成阶段的API
+type Completer = Completable
@@ -23,7 +23,6 @@ import (
 	"github.com/xfali/executor"
 	"reflect"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -32,22 +31,21 @@ const (
 	DefaultExecBufferSize = 256
 )
 
-var defaultExecutor executor.Executor
-
-func init() {
-	//defaultExecutor = executor.NewFixedBufExecutor(DefaultExecutorSize, DefaultExecBufferSize)
-	defaultExecutor = &UnlimitedExecutor{}
+// 设置默认引擎的协程池
+func SetDefaultExecutor(executor executor.Executor) {
+	defaultEngine.SetExecutor(executor)
 }
 
-func SetDefaultExecutor(executor executor.Executor) {
-	defaultExecutor = executor
+// 设置默认引擎的类型转换模式，只影响之后创建的阶段，默认为functools.DefaultConvertMode
+func SetConvertMode(mode functools.ConvertMode) {
+	defaultEngine.SetConvertMode(mode)
 }
 
-const (
-	completableFutureNone = iota
-	completableFutureDone
-	completableFutureCancel
-)
+// 设置默认引擎的后续*Async阶段是否继承上一阶段的协程池，默认继承
+// 设置为false时恢复旧版本行为：未指定协程池的*Async阶段使用默认协程池
+func SetInheritExecutor(inherit bool) {
+	defaultEngine.SetInheritExecutor(inherit)
+}
 
 // 注意CompletableFuture的修改原则：
 // 1、每个返回的CompletableFuture中的ValueHandler都必须有一个Set操作，不论是value、error、panic（目前无error）
@@ -55,41 +53,61 @@ const (
 //
 //	所以在开发时，要么在创建返回CompletableFuture之前就panic，要么就捕捉panic然后ValueHandler SetPanic
 type defaultCompletableFuture struct {
-	vType      reflect.Type
-	v          ValueHandler
+	v      ValueHandler
+	engine *Engine
+	name   string
+	// 阶段执行所用（或从上一阶段继承）的协程池，后续的*Async阶段默认继承
+	exec       executor.Executor
 	ctx        context.Context
-	cancelFunc context.CancelFunc
-
-	status int32
-
-	lock   sync.Mutex
-	result ValueOrError
+	cancelFunc context.CancelCauseFunc
+
+	// 只读视图不能完成或取消
+	readOnly bool
+
+	// 阶段选项指定的panic策略，为nil时使用引擎的策略
+	panicPolicy *PanicPolicy
+	// 阶段选项指定的拒绝策略，零值时使用协程池的策略
+	rejection RejectionPolicy
+	// 阶段选项指定（或从上一阶段继承）的优先级，为nil时未指定
+	priority *int
+	// 创建时引擎的类型转换模式
+	convert functools.ConvertMode
+	// 创建时引擎的取消模式
+	cancelMode CancelMode
+
+	// 未被取消的后续阶段数，CancelBranch模式使用
+	refs int32
+
+	// Done返回的channel及其对应的ValueHandler channel
+	lock    sync.Mutex
+	done    <-chan struct{}
+	doneFor <-chan struct{}
 }
 
-func newCf(pCtx context.Context, v *defaultValueHandler) *defaultCompletableFuture {
+func newCf(e *Engine, pCtx context.Context, v *defaultValueHandler) *defaultCompletableFuture {
 	ret := &defaultCompletableFuture{
-		v:      v,
-		result: nil,
-	}
-	if v != nil {
-		ret.vType = v.Type()
+		v:          v,
+		engine:     e,
+		convert:    e.ConvertMode(),
+		cancelMode: e.CancelMode(),
 	}
+	v.bind(e, ret)
 	if pCtx != nil {
-		ctx, cancel := context.WithCancel(pCtx)
+		ctx, cancel := context.WithCancelCause(pCtx)
 		ret.ctx = ctx
 		ret.cancelFunc = cancel
 	}
 	return ret
 }
 
-func newCfWithCancel(cCtx context.Context, cancelFunc context.CancelFunc, v *defaultValueHandler) *defaultCompletableFuture {
+func newCfWithCancel(e *Engine, cCtx context.Context, cancelFunc context.CancelCauseFunc, v *defaultValueHandler) *defaultCompletableFuture {
 	ret := &defaultCompletableFuture{
-		v:      v,
-		result: nil,
-	}
-	if v != nil {
-		ret.vType = v.Type()
+		v:          v,
+		engine:     e,
+		convert:    e.ConvertMode(),
+		cancelMode: e.CancelMode(),
 	}
+	v.bind(e, ret)
 	if cCtx != nil {
 		ret.ctx = cCtx
 		ret.cancelFunc = cancelFunc
@@ -101,28 +119,27 @@ func newCfWithCancel(cCtx context.Context, cancelFunc context.CancelFunc, v *def
 // Param：参数函数：f func(o TYPE1) TYPE2参数为上阶段结果，返回为处理后的返回值
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenApply(applyFunc interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
 	cf.checkValue()
 
 	fnValue := reflect.ValueOf(applyFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckApplyFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckApplyFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	vh := NewSyncHandler(fnValue.Type().Out(0))
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
+	vh := NewSyncHandler(functools.OutType(fnValue.Type()))
+	retCf = newDependent(vh, cf)
 	defer handlePanic(vh)
+	vh.setRunning()
 
-	ve := cf.getValue(ctx)
+	ve := cf.getValue(cf.ctx)
 	if !ve.HaveValue() {
 		vh.SetValueOrError(ve.Clone())
 		return
 	}
 
-	err := vh.SetValue(functools.RunApply(fnValue, ve.GetValue()))
+	err := vh.SetValue(cf.convertMode().RunApply(fnValue, ve.GetValue()))
 	if err != nil {
 		vh.SetPanic(err)
 	}
@@ -136,25 +153,23 @@ func (cf *defaultCompletableFuture) ThenApplyAsync(applyFunc interface{}, execut
 	cf.checkValue()
 
 	fnValue := reflect.ValueOf(applyFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckApplyFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckApplyFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	vh := NewAsyncHandler(fnValue.Type().Out(0))
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve := cf.getValue(cf.ctx)
+	vh := NewAsyncHandler(functools.OutType(fnValue.Type()))
+	ret := newDependent(vh, cf)
+	retCf = ret
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve := cf.getValue(upstreamCtx(cf.ctx, taskCtx))
 		if !ve.HaveValue() {
 			vh.SetValueOrError(ve.Clone())
 			return
 		}
-		err := vh.SetValue(functools.RunApply(fnValue, ve.GetValue()))
+		err := vh.SetValue(cf.convertMode().RunApply(fnValue, ve.GetValue()))
 		if err != nil {
 			vh.SetPanic(err)
 		}
@@ -169,27 +184,26 @@ func (cf *defaultCompletableFuture) ThenApplyAsync(applyFunc interface{}, execut
 // Param：参数函数：f func(o TYPE)参数为上阶段结果
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenAccept(acceptFunc interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
 	cf.checkValue()
 
 	fnValue := reflect.ValueOf(acceptFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckAcceptFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckAcceptFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
 	vh := NewSyncHandler(functools.NilType)
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
+	retCf = newDependent(vh, cf)
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve := cf.getValue(cf.ctx)
 	if !ve.HaveValue() {
 		vh.SetValueOrError(ve.Clone())
 		return
 	}
 
-	functools.RunAccept(fnValue, ve.GetValue())
+	cf.convertMode().RunAccept(fnValue, ve.GetValue())
 	vh.SetValue(functools.NilValue)
 	return
 }
@@ -201,25 +215,23 @@ func (cf *defaultCompletableFuture) ThenAcceptAsync(acceptFunc interface{}, exec
 	cf.checkValue()
 
 	fnValue := reflect.ValueOf(acceptFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckAcceptFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckAcceptFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
 	vh := NewAsyncHandler(functools.NilType)
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve := cf.getValue(cf.ctx)
+	ret := newDependent(vh, cf)
+	retCf = ret
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve := cf.getValue(upstreamCtx(cf.ctx, taskCtx))
 		if !ve.HaveValue() {
 			vh.SetValueOrError(ve.Clone())
 			return
 		}
-		functools.RunAccept(fnValue, ve.GetValue())
+		cf.convertMode().RunAccept(fnValue, ve.GetValue())
 		vh.SetValue(functools.NilValue)
 	})
 	if err != nil {
@@ -232,20 +244,17 @@ func (cf *defaultCompletableFuture) ThenAcceptAsync(acceptFunc interface{}, exec
 // Param：参数函数: f func()
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenRun(runnable interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
 	cf.checkValue()
 
 	fnValue := reflect.ValueOf(runnable)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
-			panic(err)
-		}
+	if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
+		panic(err)
 	}
 
 	vh := NewSyncHandler(functools.NilType)
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
+	retCf = newDependent(vh, cf)
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve := cf.getValue(cf.ctx)
 	if !ve.HaveValue() {
 		vh.SetValueOrError(ve.Clone())
@@ -259,25 +268,21 @@ func (cf *defaultCompletableFuture) ThenRun(runnable interface{}) (retCf Complet
 
 // 当阶段正常完成时执行参数函数：不关心上一步结果
 // Param：参数函数: f func()
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenRunAsync(runnable interface{}, executor ...executor.Executor) (retCf CompletionStage) {
 	cf.checkValue()
 
 	fnValue := reflect.ValueOf(runnable)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
-			panic(err)
-		}
+	if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
+		panic(err)
 	}
 	vh := NewAsyncHandler(functools.NilType)
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve := cf.getValue(cf.ctx)
+	ret := newDependent(vh, cf)
+	retCf = ret
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve := cf.getValue(upstreamCtx(cf.ctx, taskCtx))
 		if !ve.HaveValue() {
 			vh.SetValueOrError(ve.Clone())
 			return
@@ -296,26 +301,21 @@ func (cf *defaultCompletableFuture) ThenRunAsync(runnable interface{}, executor
 // Param：参数函数，combineFunc func(TYPE1, TYPE2) TYPE3参数为两个CompletionStage的结果，返回转化结果
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenCombine(other CompletionStage, combineFunc interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 
 	fnValue := reflect.ValueOf(combineFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckCombineFunction(fnValue.Type(), cf.vType, ocf.vType); err != nil {
+	if cf.valueType() != nil && ocf.valueType() != nil {
+		if err := cf.convertMode().CheckCombineFunction(fnValue.Type(), cf.valueType(), ocf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	octx, _ := context.WithCancel(cf.ctx)
-
-	vh := NewSyncHandler(fnValue.Type().Out(0))
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
+	vh := NewSyncHandler(functools.OutType(fnValue.Type()))
+	retCf = newDependent(vh, cf, ocf)
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve1, ve2 := cf.v.BothValue(ocf.v, cf.ctx)
 	if !ve1.HaveValue() {
 		vh.SetValueOrError(ve1.Clone())
@@ -327,7 +327,7 @@ func (cf *defaultCompletableFuture) ThenCombine(other CompletionStage, combineFu
 		return
 	}
 
-	err := vh.SetValue(functools.RunCombine(fnValue, ve1.GetValue(), ve2.GetValue()))
+	err := vh.SetValue(cf.convertMode().RunCombine(fnValue, ve1.GetValue(), ve2.GetValue()))
 	if err != nil {
 		vh.SetPanic(err)
 	}
@@ -337,35 +337,30 @@ func (cf *defaultCompletableFuture) ThenCombine(other CompletionStage, combineFu
 // 当阶段正常完成时执行参数函数：结合两个CompletionStage的结果，转化后返回
 // Param：other，当该CompletionStage也返回后进行结合转化
 // Param：参数函数，combineFunc func(TYPE1, TYPE2) TYPE3参数为两个CompletionStage的结果，返回转化结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenCombineAsync(
 	other CompletionStage,
 	combineFunc interface{},
 	executor ...executor.Executor) (retCf CompletionStage) {
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 
 	fnValue := reflect.ValueOf(combineFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckCombineFunction(fnValue.Type(), cf.vType, ocf.vType); err != nil {
+	if cf.valueType() != nil && ocf.valueType() != nil {
+		if err := cf.convertMode().CheckCombineFunction(fnValue.Type(), cf.valueType(), ocf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	vh := NewAsyncHandler(fnValue.Type().Out(0))
+	vh := NewAsyncHandler(functools.OutType(fnValue.Type()))
 
-	octx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve1, ve2 := cf.v.BothValue(ocf.v, nil)
+	ret := newDependent(vh, cf, ocf)
+	retCf = ret
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve1, ve2 := cf.v.BothValue(ocf.v, upstreamCtx(nil, taskCtx))
 		if !ve1.HaveValue() {
 			vh.SetValueOrError(ve1.Clone())
 			return
@@ -376,7 +371,7 @@ func (cf *defaultCompletableFuture) ThenCombineAsync(
 			return
 		}
 
-		err := vh.SetValue(functools.RunCombine(fnValue, ve1.GetValue(), ve2.GetValue()))
+		err := vh.SetValue(cf.convertMode().RunCombine(fnValue, ve1.GetValue(), ve2.GetValue()))
 		if err != nil {
 			vh.SetPanic(err)
 		}
@@ -393,26 +388,21 @@ func (cf *defaultCompletableFuture) ThenCombineAsync(
 // Param：参数函数，acceptFunc func(TYPE1, TYPE2) 参数为两个CompletionStage的结果
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenAcceptBoth(other CompletionStage, acceptFunc interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 
 	fnValue := reflect.ValueOf(acceptFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckAcceptBothFunction(fnValue.Type(), cf.vType, ocf.vType); err != nil {
+	if cf.valueType() != nil && ocf.valueType() != nil {
+		if err := cf.convertMode().CheckAcceptBothFunction(fnValue.Type(), cf.valueType(), ocf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	octx, _ := context.WithCancel(cf.ctx)
-
 	vh := NewSyncHandler(functools.NilType)
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
+	retCf = newDependent(vh, cf, ocf)
 	defer handlePanic(vh)
+	vh.setRunning()
 
 	ve1, ve2 := cf.v.BothValue(ocf.v, cf.ctx)
 	if !ve1.HaveValue() {
@@ -425,7 +415,7 @@ func (cf *defaultCompletableFuture) ThenAcceptBoth(other CompletionStage, accept
 		return
 	}
 
-	functools.RunAcceptBoth(fnValue, ve1.GetValue(), ve2.GetValue())
+	cf.convertMode().RunAcceptBoth(fnValue, ve1.GetValue(), ve2.GetValue())
 	vh.SetValue(functools.NilValue)
 	return
 }
@@ -433,35 +423,29 @@ func (cf *defaultCompletableFuture) ThenAcceptBoth(other CompletionStage, accept
 // 当阶段正常完成时执行参数函数：结合两个CompletionStage的结果，进行消耗
 // Param：other，当该CompletionStage也返回后进行消耗
 // Param：参数函数，acceptFunc func(TYPE1, TYPE2) 参数为两个CompletionStage的结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenAcceptBothAsync(
 	other CompletionStage,
 	acceptFunc interface{},
 	executor ...executor.Executor) (retCf CompletionStage) {
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 
 	fnValue := reflect.ValueOf(acceptFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckAcceptBothFunction(fnValue.Type(), cf.vType, ocf.vType); err != nil {
+	if cf.valueType() != nil && ocf.valueType() != nil {
+		if err := cf.convertMode().CheckAcceptBothFunction(fnValue.Type(), cf.valueType(), ocf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	octx, _ := context.WithCancel(cf.ctx)
-
 	vh := NewAsyncHandler(functools.NilType)
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve1, ve2 := cf.v.BothValue(ocf.v, cf.ctx)
+	ret := newDependent(vh, cf, ocf)
+	retCf = ret
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve1, ve2 := cf.v.BothValue(ocf.v, upstreamCtx(cf.ctx, taskCtx))
 		if !ve1.HaveValue() {
 			vh.SetValueOrError(ve1.Clone())
 			return
@@ -472,7 +456,7 @@ func (cf *defaultCompletableFuture) ThenAcceptBothAsync(
 			return
 		}
 
-		functools.RunAcceptBoth(fnValue, ve1.GetValue(), ve2.GetValue())
+		cf.convertMode().RunAcceptBoth(fnValue, ve1.GetValue(), ve2.GetValue())
 		vh.SetValue(functools.NilValue)
 	})
 	if err != nil {
@@ -487,26 +471,19 @@ func (cf *defaultCompletableFuture) ThenAcceptBothAsync(
 // Param：参数函数 runnable func()
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) RunAfterBoth(other CompletionStage, runnable interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 
 	fnValue := reflect.ValueOf(runnable)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
-			panic(err)
-		}
+	if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
+		panic(err)
 	}
 
-	octx, _ := context.WithCancel(cf.ctx)
-
 	vh := NewSyncHandler(functools.NilType)
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
+	retCf = newDependent(vh, cf, ocf)
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve1, ve2 := cf.v.BothValue(ocf.v, cf.ctx)
 	if !ve1.HaveValue() {
 		vh.SetValueOrError(ve1.Clone())
@@ -526,35 +503,27 @@ func (cf *defaultCompletableFuture) RunAfterBoth(other CompletionStage, runnable
 // 当阶段正常完成时执行参数函数：两个CompletionStage都完成后执行
 // Param：other，当该CompletionStage也完成后执行参数函数
 // Param：参数函数 runnable func()
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) RunAfterBothAsync(
 	other CompletionStage,
 	runnable interface{},
 	executor ...executor.Executor) (retCf CompletionStage) {
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 
 	fnValue := reflect.ValueOf(runnable)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
-			panic(err)
-		}
+	if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
+		panic(err)
 	}
-	octx, _ := context.WithCancel(cf.ctx)
-
 	vh := NewAsyncHandler(functools.NilType)
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
-
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve1, ve2 := cf.v.BothValue(ocf.v, cf.ctx)
+	ret := newDependent(vh, cf, ocf)
+	retCf = ret
+
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve1, ve2 := cf.v.BothValue(ocf.v, upstreamCtx(cf.ctx, taskCtx))
 		if !ve1.HaveValue() {
 			vh.SetValueOrError(ve1.Clone())
 			return
@@ -578,34 +547,29 @@ func (cf *defaultCompletableFuture) RunAfterBothAsync(
 // Param：参数函数 f func(o Type1) Type2参数为先完成的CompletionStage的结果，返回转化结果
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ApplyToEither(other CompletionStage, applyFunc interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 	cf.checkSameType(ocf)
 
 	fnValue := reflect.ValueOf(applyFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckApplyFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckApplyFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	octx, _ := context.WithCancel(cf.ctx)
-
-	vh := NewSyncHandler(fnValue.Type().Out(0))
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
+	vh := NewSyncHandler(functools.OutType(fnValue.Type()))
+	retCf = newDependent(vh, cf, ocf)
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve := cf.v.SelectValue(ocf.v, cf.ctx)
 	if !ve.HaveValue() {
 		vh.SetValueOrError(ve.Clone())
 		return
 	}
 
-	err := vh.SetValue(functools.RunApply(fnValue, ve.GetValue()))
+	err := vh.SetValue(cf.convertMode().RunApply(fnValue, ve.GetValue()))
 	if err != nil {
 		vh.SetPanic(err)
 	}
@@ -615,42 +579,36 @@ func (cf *defaultCompletableFuture) ApplyToEither(other CompletionStage, applyFu
 // 当阶段正常完成时执行参数函数：两个CompletionStage使用先完成的结果进行转化
 // Param：other，与该CompletionStage比较，用先完成的结果进行转化，注意两个CompletionStage的返回结果类型必须相同
 // Param：参数函数 f func(o Type1) Type2参数为先完成的CompletionStage的结果，返回转化结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ApplyToEitherAsync(
 	other CompletionStage,
 	applyFunc interface{},
 	executor ...executor.Executor) (retCf CompletionStage) {
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 	cf.checkSameType(ocf)
 
 	fnValue := reflect.ValueOf(applyFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckApplyFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckApplyFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	octx, _ := context.WithCancel(cf.ctx)
-
-	vh := NewAsyncHandler(fnValue.Type().Out(0))
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve := cf.v.SelectValue(ocf.v, cf.ctx)
+	vh := NewAsyncHandler(functools.OutType(fnValue.Type()))
+	ret := newDependent(vh, cf, ocf)
+	retCf = ret
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve := cf.v.SelectValue(ocf.v, upstreamCtx(cf.ctx, taskCtx))
 		if !ve.HaveValue() {
 			vh.SetValueOrError(ve.Clone())
 			return
 		}
 
-		err := vh.SetValue(functools.RunApply(fnValue, ve.GetValue()))
+		err := vh.SetValue(cf.convertMode().RunApply(fnValue, ve.GetValue()))
 		if err != nil {
 			vh.SetPanic(err)
 		}
@@ -667,34 +625,29 @@ func (cf *defaultCompletableFuture) ApplyToEitherAsync(
 // Param：参数函数  f func(o Type)参数为先完成的CompletionStage的结果
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) AcceptEither(other CompletionStage, acceptFunc interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 	cf.checkSameType(ocf)
 
 	fnValue := reflect.ValueOf(acceptFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckAcceptFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckAcceptFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	octx, _ := context.WithCancel(cf.ctx)
-
 	vh := NewSyncHandler(functools.NilType)
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
+	retCf = newDependent(vh, cf, ocf)
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve := cf.v.SelectValue(ocf.v, cf.ctx)
 	if !ve.HaveValue() {
 		vh.SetValueOrError(ve.Clone())
 		return
 	}
 
-	functools.RunAccept(fnValue, ve.GetValue())
+	cf.convertMode().RunAccept(fnValue, ve.GetValue())
 	err := vh.SetValue(functools.NilValue)
 	if err != nil {
 		vh.SetPanic(err)
@@ -705,42 +658,36 @@ func (cf *defaultCompletableFuture) AcceptEither(other CompletionStage, acceptFu
 // 当阶段正常完成时执行参数函数：两个CompletionStage使用先完成的结果进行消耗
 // Param：other，与该CompletionStage比较，用先完成的结果进行消耗，注意两个CompletionStage的返回结果类型必须相同
 // Param：参数函数 f func(o Type)参数为先完成的CompletionStage的结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) AcceptEitherAsync(
 	other CompletionStage,
 	acceptFunc interface{},
 	executor ...executor.Executor) (retCf CompletionStage) {
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 	cf.checkSameType(ocf)
 
 	fnValue := reflect.ValueOf(acceptFunc)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckAcceptFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckAcceptFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	octx, _ := context.WithCancel(cf.ctx)
-
 	vh := NewAsyncHandler(functools.NilType)
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve := cf.v.SelectValue(ocf.v, cf.ctx)
+	ret := newDependent(vh, cf, ocf)
+	retCf = ret
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve := cf.v.SelectValue(ocf.v, upstreamCtx(cf.ctx, taskCtx))
 		if !ve.HaveValue() {
 			vh.SetValueOrError(ve.Clone())
 			return
 		}
 
-		functools.RunAccept(fnValue, ve.GetValue())
+		cf.convertMode().RunAccept(fnValue, ve.GetValue())
 		err := vh.SetValue(functools.NilValue)
 		if err != nil {
 			vh.SetPanic(err)
@@ -757,27 +704,20 @@ func (cf *defaultCompletableFuture) AcceptEitherAsync(
 // Param：参数函数
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) RunAfterEither(other CompletionStage, runnable interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 	cf.checkSameType(ocf)
 
 	fnValue := reflect.ValueOf(runnable)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
-			panic(err)
-		}
+	if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
+		panic(err)
 	}
 
-	octx, _ := context.WithCancel(cf.ctx)
-
 	vh := NewSyncHandler(functools.NilType)
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
+	retCf = newDependent(vh, cf, ocf)
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve := cf.v.SelectValue(ocf.v, cf.ctx)
 	if !ve.HaveValue() {
 		vh.SetValueOrError(ve.Clone())
@@ -795,35 +735,27 @@ func (cf *defaultCompletableFuture) RunAfterEither(other CompletionStage, runnab
 // 当阶段正常完成时执行参数函数：两个CompletionStage任意一个完成则执行操作
 // Param：other，与该CompletionStage比较，任意一个完成则执行操作，注意两个CompletionStage的返回结果类型必须相同
 // Param：参数函数
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) RunAfterEitherAsync(
 	other CompletionStage,
 	runnable interface{},
 	executor ...executor.Executor) (retCf CompletionStage) {
-	ocf := convert(other)
+	ocf := cf.convertStage(other)
 	cf.checkValue()
 	ocf.checkValue()
 	cf.checkSameType(ocf)
 
 	fnValue := reflect.ValueOf(runnable)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
-			panic(err)
-		}
+	if err := functools.CheckRunnableFunction(fnValue.Type()); err != nil {
+		panic(err)
 	}
-	octx, _ := context.WithCancel(cf.ctx)
-
 	vh := NewAsyncHandler(functools.NilType)
-	retCf = newCfWithCancel(octx, func() {
-		cf.cancelFunc()
-		ocf.cancelFunc()
-	}, vh)
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve := cf.v.SelectValue(ocf.v, cf.ctx)
+	ret := newDependent(vh, cf, ocf)
+	retCf = ret
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve := cf.v.SelectValue(ocf.v, upstreamCtx(cf.ctx, taskCtx))
 		if !ve.HaveValue() {
 			vh.SetValueOrError(ve.Clone())
 			return
@@ -842,82 +774,57 @@ func (cf *defaultCompletableFuture) RunAfterEitherAsync(
 }
 
 // 当阶段正常完成时执行参数函数：使用上一阶段结果转化为新的CompletionStage
-// Param：参数函数，f func(o TYPE) CompletionStage 参数：上一阶段结果，返回新的CompletionStage
+// Param：参数函数，f func(o TYPE) CompletionStage 参数：上一阶段结果，返回新的CompletionStage，
+// 也可以为f func(ctx context.Context, o TYPE) CompletionStage，ctx为新阶段的context
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenCompose(f interface{}) (retCf CompletionStage) {
-	defer cf.setDone()
 	cf.checkValue()
 
 	fnValue := reflect.ValueOf(f)
-	if err := checkComposeFunction(fnValue.Type(), cf.vType); err != nil {
+	if err := checkComposeFunction(cf.convertMode(), fnValue.Type(), cf.valueType()); err != nil {
 		panic(err)
 	}
 
-	vh := NewSyncHandler(functools.NilType)
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
+	vh := NewSyncHandler(nil)
+	ret := newDependent(vh, cf)
+	retCf = ret
 
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve := cf.getValue(cf.ctx)
 	if !ve.HaveValue() {
 		vh.SetValueOrError(ve.Clone())
 		return
 	}
-	newCom := functools.RunCompose(fnValue, ve.GetValue())
-	if newCom.IsValid() {
-		i := newCom.Interface()
-		if i == nil {
-			panic("Return CompletionStage is nil. ")
-		}
-		return i.(CompletionStage)
-		//err := ncf.v.SetValue(ve.GetValue())
-		//if err != nil {
-		//	ncf.v.SetPanic(err)
-		//}
-		//return ncf
-	}
+	ret.flatten(cf.convertMode().RunCompose(bindContext(fnValue, ret.ctx), ve.GetValue()))
 	return
 }
 
 // 当阶段正常完成时执行参数函数：使用上一阶段结果转化为新的CompletionStage
-// Param：参数函数，f func(o TYPE) CompletionStage 参数：上一阶段结果，返回新的CompletionStage
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：参数函数，f func(o TYPE) CompletionStage 参数：上一阶段结果，返回新的CompletionStage，
+// 也可以为f func(ctx context.Context, o TYPE) CompletionStage，ctx为任务的context，参考WithContext
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) ThenComposeAsync(f interface{}, executor ...executor.Executor) (retCf CompletionStage) {
 	cf.checkValue()
 
 	fnValue := reflect.ValueOf(f)
-	if err := checkComposeFunction(fnValue.Type(), cf.vType); err != nil {
+	if err := checkComposeFunction(cf.convertMode(), fnValue.Type(), cf.valueType()); err != nil {
 		panic(err)
 	}
 
-	vh := NewAsyncHandler(composeCfType)
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
+	vh := NewAsyncHandler(nil)
+	ret := newDependent(vh, cf)
+	retCf = ret
 
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer cf.setDone()
-		ve := cf.getValue(cf.ctx)
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(taskCtx context.Context) {
+		ve := cf.getValue(upstreamCtx(cf.ctx, taskCtx))
 		if !ve.HaveValue() {
 			vh.SetValueOrError(ve.Clone())
 			return
 		}
-		newCom := functools.RunCompose(fnValue, ve.GetValue())
-		if newCom.IsValid() {
-			i := newCom.Interface()
-			if i == nil {
-				vh.SetPanic(errors.New("Return CompletionStage is nil. "))
-				return
-			}
-			err := vh.SetValue(reflect.ValueOf(&composeCf{joinVe: i.(Joinable)}))
-			if err != nil {
-				vh.SetPanic(err)
-			}
-		} else {
-			vh.SetPanic(errors.New("Return CompletionStage is nil. "))
-		}
+		ret.flatten(cf.convertMode().RunCompose(bindContext(fnValue, ownerCtx(taskCtx)), ve.GetValue()))
 	})
 	if err != nil {
 		vh.SetPanic(err)
@@ -926,35 +833,42 @@ func (cf *defaultCompletableFuture) ThenComposeAsync(f interface{}, executor ...
 	return
 }
 
+// 展开ThenCompose参数函数返回的CompletionStage：内部阶段结束时阶段获得相同的结果及类型
+// 取消阶段时取消内部阶段，内部阶段被取消时阶段也被取消
+// 等待内部阶段不占用协程，内部阶段结束后通过阶段的协程池设置结果
+func (cf *defaultCompletableFuture) flatten(stage reflect.Value) {
+	vh := cf.handler()
+	if !stage.IsValid() || stage.IsNil() {
+		vh.SetPanic(errors.New("Return CompletionStage is nil. "))
+		return
+	}
+	inner := cf.convertStage(stage.Interface().(CompletionStage))
+	stop := context.AfterFunc(cf.ctx, func() {
+		inner.CancelWithCause(context.Cause(cf.ctx))
+	})
+	exec := cf.chooseExecutor(cf.exec)
+	remove := inner.handler().onComplete(func() {
+		stop()
+		err := cf.submit(exec, false, func(context.Context) {
+			ve, _ := inner.Result()
+			vh.resolveType(inner.valueType())
+			vh.SetValueOrError(ve.Clone())
+		})
+		if err != nil {
+			vh.SetPanic(err)
+		}
+	})
+	// 阶段先结束（如被取消）时不再等待内部阶段
+	vh.onComplete(remove)
+}
+
 func (cf *defaultCompletableFuture) JoinCompletionStage(ctx context.Context) CompletionStage {
 	return cf
 }
 
 // 尝试获得ValueOrError
-// 此处还负责处理ComposeAsync封装的CompletableFuture，该设计可能不那么“优雅”
 func (cf *defaultCompletableFuture) getValue(ctx context.Context) ValueOrError {
-	ve := cf.v.Get(ctx)
-	if ve.GetError() == nil {
-		v := ve.GetValue()
-		if v.IsValid() && !v.IsZero() {
-			if c, ok := v.Interface().(*composeCf); ok {
-				ve = c.joinVe.JoinCompletionStage(ctx).(*defaultCompletableFuture).getValue(ctx)
-			}
-		}
-	}
-	return ve
-}
-
-func (cf *defaultCompletableFuture) getValueAndCache(ctx context.Context) ValueOrError {
-	cf.lock.Lock()
-	defer cf.lock.Unlock()
-
-	if cf.result != nil {
-		return cf.result
-	}
-
-	cf.result = cf.getValue(ctx)
-	return cf.result
+	return cf.v.Get(ctx)
 }
 
 // 捕获阶段异常，返回补偿结果
@@ -963,16 +877,16 @@ func (cf *defaultCompletableFuture) getValueAndCache(ctx context.Context) ValueO
 func (cf *defaultCompletableFuture) Exceptionally(f interface{}) (retCf CompletionStage) {
 	cf.checkValue()
 	fnValue := reflect.ValueOf(f)
-	if !cf.skipFuncCheck() {
+	if cf.valueType() != nil {
 		if err := functools.CheckPanicFunction(fnValue.Type()); err != nil {
 			panic(err)
 		}
 	}
 
-	vh := NewSyncHandler(fnValue.Type().Out(0))
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
+	vh := NewSyncHandler(functools.OutType(fnValue.Type()))
+	retCf = newDependent(vh, cf)
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve := cf.getValue(cf.ctx)
 	if ve.HaveValue() {
 		err := vh.SetValue(ve.GetValue())
@@ -984,11 +898,16 @@ func (cf *defaultCompletableFuture) Exceptionally(f interface{}) (retCf Completi
 	if ve.HavePanic() {
 		p := ve.GetPanic()
 		if p != nil {
-			err := vh.SetValue(functools.RunPanic(fnValue, reflect.ValueOf(p)))
+			err := vh.SetValue(cf.convertMode().RunPanic(fnValue, reflect.ValueOf(p)))
 			if err != nil {
 				vh.SetPanic(err)
 			}
 		}
+		return
+	}
+	// 上一阶段已被取消，继续传递取消状态
+	if ve.IsDone() {
+		vh.setCancel(ve.GetCancellation())
 	}
 
 	return
@@ -1000,68 +919,62 @@ func (cf *defaultCompletableFuture) Exceptionally(f interface{}) (retCf Completi
 func (cf *defaultCompletableFuture) WhenComplete(f interface{}) (retCf CompletionStage) {
 	cf.checkValue()
 	fnValue := reflect.ValueOf(f)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckWhenCompleteFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckWhenCompleteFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
 	vh := NewSyncHandler(functools.NilType)
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
+	retCf = newDependent(vh, cf)
 
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve := cf.getValue(cf.ctx)
 	v := ve.GetValue()
 	if !v.IsValid() {
-		v = reflect.New(cf.vType).Elem()
+		v = cf.zeroValue()
 	}
-	p := ve.GetPanic()
-	var panicV reflect.Value
-	if p == nil {
-		panicV = reflect.Zero(functools.InterfaceType)
-	} else {
-		panicV = reflect.ValueOf(p)
+	panicV := panicValue(ve)
+	cf.convertMode().RunWhenComplete(fnValue, v, panicV)
+	// 上一阶段已被取消，继续传递取消状态
+	if ve.IsDone() {
+		vh.setCancel(ve.GetCancellation())
+		return
 	}
-	functools.RunWhenComplete(fnValue, v, panicV)
-
 	vh.SetValue(functools.NilValue)
 	return
 }
 
 // 阶段执行时获得结果或者panic,注意会继续传递panic
 // Param：参数函数，f func(result Type, panic interface{}) 参数result：结果，参数panic：异常
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) WhenCompleteAsync(f interface{}, executor ...executor.Executor) (retCf CompletionStage) {
 	cf.checkValue()
 	fnValue := reflect.ValueOf(f)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckWhenCompleteFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckWhenCompleteFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 	vh := NewAsyncHandler(functools.NilType)
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
-
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
+	ret := newDependent(vh, cf)
+	retCf = ret
 
-		ve := cf.getValue(cf.ctx)
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, false, func(taskCtx context.Context) {
+		ve := cf.getValue(upstreamCtx(cf.ctx, taskCtx))
 		v := ve.GetValue()
 		if !v.IsValid() {
-			v = reflect.New(cf.vType).Elem()
+			v = cf.zeroValue()
 		}
-		p := ve.GetPanic()
-		var panicV reflect.Value
-		if p == nil {
-			panicV = reflect.Zero(functools.InterfaceType)
-		} else {
-			panicV = reflect.ValueOf(p)
+		panicV := panicValue(ve)
+		cf.convertMode().RunWhenComplete(fnValue, v, panicV)
+		if ve.IsDone() {
+			vh.setCancel(ve.GetCancellation())
+			return
 		}
-		functools.RunWhenComplete(fnValue, v, panicV)
 		vh.SetValue(functools.NilValue)
 	})
 	if err != nil {
@@ -1076,31 +989,30 @@ func (cf *defaultCompletableFuture) WhenCompleteAsync(f interface{}, executor ..
 func (cf *defaultCompletableFuture) Handle(f interface{}) (retCf CompletionStage) {
 	cf.checkValue()
 	fnValue := reflect.ValueOf(f)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckHandleFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckHandleFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	vh := NewSyncHandler(fnValue.Type().Out(0))
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
+	vh := NewSyncHandler(functools.OutType(fnValue.Type()))
+	retCf = newDependent(vh, cf)
 
 	defer handlePanic(vh)
+	vh.setRunning()
 	ve := cf.getValue(cf.ctx)
 	v := ve.GetValue()
 	if !v.IsValid() {
-		v = reflect.New(cf.vType).Elem()
+		v = cf.zeroValue()
 	}
-	p := ve.GetPanic()
-
-	var panicV reflect.Value
-	if p == nil {
-		panicV = reflect.Zero(functools.InterfaceType)
-	} else {
-		panicV = reflect.ValueOf(p)
+	panicV := panicValue(ve)
+	ret := cf.convertMode().RunHandle(fnValue, v, panicV)
+	// 上一阶段已被取消，继续传递取消状态
+	if ve.IsDone() {
+		vh.setCancel(ve.GetCancellation())
+		return
 	}
-	err := vh.SetValue(functools.RunHandle(fnValue, v, panicV))
+	err := vh.SetValue(ret)
 	if err != nil {
 		vh.SetPanic(err)
 	}
@@ -1110,38 +1022,35 @@ func (cf *defaultCompletableFuture) Handle(f interface{}) (retCf CompletionStage
 
 // 阶段执行时获得结果或者panic,并转化结果
 // Param：参数函数，f func(result TYPE1, panic interface{}) TYPE2 参数result：结果，参数panic：异常，返回：转化的结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *defaultCompletableFuture) HandleAsync(f interface{}, executor ...executor.Executor) (retCf CompletionStage) {
 	cf.checkValue()
 	fnValue := reflect.ValueOf(f)
-	if !cf.skipFuncCheck() {
-		if err := functools.CheckHandleFunction(fnValue.Type(), cf.vType); err != nil {
+	if cf.valueType() != nil {
+		if err := cf.convertMode().CheckHandleFunction(fnValue.Type(), cf.valueType()); err != nil {
 			panic(err)
 		}
 	}
 
-	vh := NewAsyncHandler(fnValue.Type().Out(0))
-	ctx, _ := context.WithCancel(cf.ctx)
-	retCf = newCfWithCancel(ctx, cf.cancelFunc, vh)
+	vh := NewAsyncHandler(functools.OutType(fnValue.Type()))
+	ret := newDependent(vh, cf)
+	retCf = ret
 
-	exec := cf.chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		ve := cf.getValue(cf.ctx)
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, false, func(taskCtx context.Context) {
+		ve := cf.getValue(upstreamCtx(cf.ctx, taskCtx))
 		v := ve.GetValue()
 		if !v.IsValid() {
-			v = reflect.New(cf.vType).Elem()
+			v = cf.zeroValue()
 		}
-		p := ve.GetPanic()
-
-		var panicV reflect.Value
-		if p == nil {
-			panicV = reflect.Zero(functools.InterfaceType)
-		} else {
-			panicV = reflect.ValueOf(p)
+		panicV := panicValue(ve)
+		ret := cf.convertMode().RunHandle(fnValue, v, panicV)
+		if ve.IsDone() {
+			vh.setCancel(ve.GetCancellation())
+			return
 		}
-		err := vh.SetValue(functools.RunHandle(fnValue, v, panicV))
+		err := vh.SetValue(ret)
 		if err != nil {
 			vh.SetPanic(err)
 		}
@@ -1156,73 +1065,223 @@ func (cf *defaultCompletableFuture) HandleAsync(f interface{}, executor ...execu
 
 // 给予get的值并正常结束
 func (cf *defaultCompletableFuture) Complete(v interface{}) error {
-	return cf.v.SetValue(reflect.ValueOf(v))
+	if cf.readOnly {
+		return &ReadOnlyError{Op: "Complete"}
+	}
+	rv, err := valueOf(cf.convertMode(), cf.valueType(), v)
+	if err != nil {
+		return err
+	}
+	return cf.v.SetValue(rv)
 }
 
 // 发送panic，异常结束
 func (cf *defaultCompletableFuture) CompleteExceptionally(v interface{}) error {
+	if cf.readOnly {
+		return &ReadOnlyError{Op: "CompleteExceptionally"}
+	}
 	cf.v.SetPanic(v)
 	return nil
 }
 
+// 强制设置阶段的值，无论阶段是否已经结束
+// 已经读取原结果的后续阶段不受影响，之后读取结果的Get、Result及后续阶段获得新值
+func (cf *defaultCompletableFuture) ObtrudeValue(v interface{}) error {
+	if cf.readOnly {
+		return &ReadOnlyError{Op: "ObtrudeValue"}
+	}
+	rv, err := valueOf(cf.convertMode(), cf.valueType(), v)
+	if err != nil {
+		return err
+	}
+	cf.handler().obtrude(vOrErr{
+		v:      rv,
+		status: vOrErrNormal,
+	})
+	return nil
+}
+
+// 强制设置阶段的panic，无论阶段是否已经结束
+// 已经读取原结果的后续阶段不受影响，之后读取结果的Get、Result及后续阶段获得该panic
+func (cf *defaultCompletableFuture) ObtrudeException(cause interface{}) error {
+	if cf.readOnly {
+		return &ReadOnlyError{Op: "ObtrudeException"}
+	}
+	cf.handler().obtrude(vOrErr{
+		v: &panicMsg{
+			origin: cause,
+			trace:  cf.engine.stacks(),
+		},
+		status: vOrErrPanic,
+	})
+	return nil
+}
+
 // 取消并打断stage链，退出任务
 // 如果任务已完成返回false，成功取消返回true
 func (cf *defaultCompletableFuture) Cancel() bool {
+	return cf.CancelWithCause(nil)
+}
+
+// 指定取消原因，取消并打断stage链，退出任务
+// Get返回及Handle获得的*CancellationError包含该原因，cause为nil时为context.Canceled
+// 如果任务已完成返回false，成功取消返回true
+func (cf *defaultCompletableFuture) CancelWithCause(cause error) bool {
+	if cf.readOnly {
+		return false
+	}
 	if cf.cancelFunc != nil {
-		cf.setCancel()
-		cf.cancelFunc()
-		return cf.IsCancelled()
+		ret := cf.handler().setCancel(newCancellationError(cause, -1))
+		cf.cancelFunc(cause)
+		return ret
 	}
 	return false
 }
 
 // 是否在完成前被取消
 func (cf *defaultCompletableFuture) IsCancelled() bool {
-	if atomic.LoadInt32(&cf.status) == completableFutureCancel {
-		return true
-	} else {
-		select {
-		case <-cf.ctx.Done():
-			return true
-		default:
-			return false
-		}
-	}
+	return cf.State() == Cancelled
 }
 
 // 是否任务完成
 // 当任务正常完成，被取消，抛出异常都会返回true
 func (cf *defaultCompletableFuture) IsDone() bool {
-	return atomic.LoadInt32(&cf.status) != completableFutureNone
+	return cf.State().IsTerminal()
+}
+
+// 是否异常结束，异常或被取消都会返回true
+func (cf *defaultCompletableFuture) IsCompletedExceptionally() bool {
+	s := cf.State()
+	return s == Failed || s == Cancelled
+}
+
+// 获得阶段当前的生命周期状态
+// 结果已设置时由结果决定；未设置结果但阶段链已被取消时为Cancelled
+func (cf *defaultCompletableFuture) State() State {
+	vh := cf.handler()
+	switch vh.getStatus() {
+	case valueHandlerNormal:
+		return Succeeded
+	case valueHandlerError, valueHandlerPanic:
+		return Failed
+	case valueHandlerUnknown:
+		return Cancelled
+	}
+	if cf.ctx != nil && cf.ctx.Err() != nil {
+		return Cancelled
+	}
+	if vh.getStatus() == valueHandlerRunning {
+		return Running
+	}
+	return Pending
+}
+
+// 返回阶段结束时关闭的channel，可与其他channel一起select，支持任意多个观察者
+// 等待Done不会消耗阶段的结果
+func (cf *defaultCompletableFuture) Done() <-chan struct{} {
+	vhDone := cf.handler().Done()
+	select {
+	case <-vhDone:
+		return vhDone
+	default:
+	}
+
+	cf.lock.Lock()
+	defer cf.lock.Unlock()
+	// Reset后ValueHandler的channel会被替换，需要重新创建
+	if cf.done != nil && cf.doneFor == vhDone {
+		return cf.done
+	}
+	var ctxDone <-chan struct{}
+	if cf.ctx != nil {
+		ctxDone = cf.ctx.Done()
+	}
+	done := make(chan struct{})
+	cf.done, cf.doneFor = done, vhDone
+	go func() {
+		defer close(done)
+		select {
+		case <-vhDone:
+		case <-ctxDone:
+		}
+	}()
+	return done
+}
+
+// 非阻塞获得阶段的结果，阶段未结束时返回false
+func (cf *defaultCompletableFuture) Result() (ValueOrError, bool) {
+	ve, ok := cf.handler().Result()
+	if !ok && cf.ctx != nil && cf.ctx.Err() != nil {
+		return newCancelled(cf.ctx), true
+	}
+	return ve, ok
 }
 
 // 等待并获得任务执行结果
 // Param： result 目标结果，必须为同类型的指针
-// Param： timeout 等待超时时间，如果不传值则一直等待
+// Param： timeout 等待超时时间，如果不传值则一直等待；超时返回*TimeoutError，不改变阶段状态
 func (cf *defaultCompletableFuture) Get(result interface{}, timeout ...time.Duration) error {
-	defer cf.setDone()
 
 	cf.checkValue()
 	var ve ValueOrError
 	if len(timeout) > 0 {
-		ctx, _ := context.WithTimeout(cf.ctx, timeout[0])
-		ve = cf.getValueAndCache(ctx)
+		ctx, cancel := context.WithCancelCause(cf.ctx)
+		stop := cf.engine.Clock().AfterFunc(timeout[0], func() {
+			cancel(errGetTimeout)
+		})
+		ve = cf.getValue(ctx)
+		stop()
+		cancel(nil)
+		if ve.IsDone() && cancellationCause(ve.GetCancellation()) == errGetTimeout {
+			return &TimeoutError{Timeout: timeout[0]}
+		}
 	} else {
-		ve = cf.getValueAndCache(cf.ctx)
+		ve = cf.getValue(cf.ctx)
+	}
+	return cf.getResult(ve, result)
+}
+
+// 立即返回阶段结果，不阻塞也不改变阶段状态
+// 阶段已结束时与Get相同，否则将valueIfAbsent设置到result
+// Param： valueIfAbsent 阶段未结束时返回的值
+// Param： result 目标结果，必须为同类型的指针
+func (cf *defaultCompletableFuture) GetNow(valueIfAbsent interface{}, result interface{}) error {
+	cf.checkValue()
+	if ve, ok := cf.Result(); ok {
+		return cf.getResult(ve, result)
 	}
+	return setAbsent(cf.convertMode(), valueIfAbsent, result)
+}
+
+// 立即返回阶段结果，不阻塞也不改变阶段状态
+// 阶段已结束时返回true，错误与Get相同；阶段未结束时返回false，不修改result
+// Param： result 目标结果，必须为同类型的指针
+func (cf *defaultCompletableFuture) TryGet(result interface{}) (bool, error) {
+	cf.checkValue()
+	if ve, ok := cf.Result(); ok {
+		return true, cf.getResult(ve, result)
+	}
+	return false, nil
+}
+
+// 将ValueOrError设置到Get的目标参数，panic将继续抛出
+func (cf *defaultCompletableFuture) getResult(ve ValueOrError, result interface{}) error {
 	if ve.HavePanic() {
-		panicPrinter(ve.GetPanicStack())
+		if cf.getPanicPolicy() == PanicAsError {
+			return &PanicError{Value: ve.GetPanic(), Stack: ve.GetPanicStack()}
+		}
+		cf.engine.logPanic(ve.GetPanicStack())
 		panic(ve.GetPanic())
 	}
 	if ve.IsDone() {
-		return errors.New("cancelled. ")
+		return ve.GetCancellation()
 	}
 	if result == nil {
 		return nil
 	}
 	retValue := reflect.ValueOf(result)
-	if err := functools.CheckPtr(retValue.Type()); err != nil {
-		return err
+	if retValue.Kind() != reflect.Ptr || retValue.IsNil() {
+		return &InvalidResultError{Type: retValue.Type()}
 	}
 	err := ve.GetError()
 	if err != nil {
@@ -1230,25 +1289,56 @@ func (cf *defaultCompletableFuture) Get(result interface{}, timeout ...time.Dura
 	}
 	v := ve.GetValue()
 	if v.IsValid() {
-		if v.Type() == functools.NilType {
-			return errors.New("Nil type cannot be set! ")
-		}
-		retValue = retValue.Elem()
-		if !retValue.CanSet() {
-			return errors.New("Cannot set. ")
-		}
-		retValue.Set(v)
+		return setResult(cf.convertMode(), retValue.Elem(), v)
 	}
 
 	return nil
 }
 
-func (cf *defaultCompletableFuture) setDone() bool {
-	return atomic.CompareAndSwapInt32(&cf.status, completableFutureNone, completableFutureDone)
+// 将GetNow的valueIfAbsent设置到目标参数，nil则设置为目标类型的零值
+func setAbsent(mode functools.ConvertMode, valueIfAbsent interface{}, result interface{}) error {
+	if result == nil {
+		return nil
+	}
+	retValue := reflect.ValueOf(result)
+	if retValue.Kind() != reflect.Ptr || retValue.IsNil() {
+		return &InvalidResultError{Type: retValue.Type()}
+	}
+	if valueIfAbsent == nil {
+		retValue.Elem().Set(reflect.Zero(retValue.Elem().Type()))
+		return nil
+	}
+	return setResult(mode, retValue.Elem(), reflect.ValueOf(valueIfAbsent))
 }
 
-func (cf *defaultCompletableFuture) setCancel() bool {
-	return atomic.CompareAndSwapInt32(&cf.status, completableFutureNone, completableFutureCancel)
+// 将阶段结果设置到Get的目标参数
+// 1、NilType的结果不修改目标参数
+// 2、结果可赋值给目标类型（包括目标为接口类型）时直接赋值
+// 3、结果为接口类型时使用其动态值，nil则设置为目标类型的零值
+// 4、按阶段的转换模式转换结果类型
+func setResult(mode functools.ConvertMode, retValue reflect.Value, v reflect.Value) error {
+	if v.Type() == functools.NilType {
+		return nil
+	}
+	if v.Kind() == reflect.Interface && !v.Type().AssignableTo(retValue.Type()) {
+		if v.IsNil() {
+			retValue.Set(reflect.Zero(retValue.Type()))
+			return nil
+		}
+		v = v.Elem()
+	}
+	if mode.TypeMatch(v.Type(), retValue.Type()) {
+		retValue.Set(mode.ConvertValue(v, retValue.Type()))
+		return nil
+	}
+	return &ResultTypeError{
+		ValueType:  v.Type(),
+		ResultType: retValue.Type(),
+	}
+}
+
+func (cf *defaultCompletableFuture) handler() *defaultValueHandler {
+	return cf.v.(*defaultValueHandler)
 }
 
 func (cf *defaultCompletableFuture) checkValue() {
@@ -1257,15 +1347,39 @@ func (cf *defaultCompletableFuture) checkValue() {
 	}
 }
 
+// 类型未确定的阶段（如桥接或展开的阶段）不检查，运行时再校验
 func (cf *defaultCompletableFuture) checkSameType(other *defaultCompletableFuture) {
-	if cf.vType != other.vType {
+	if cf.valueType() == nil || other.valueType() == nil {
+		return
+	}
+	if cf.valueType() != other.valueType() {
 		panic("Not same type!")
 	}
 }
 
-func (cf *defaultCompletableFuture) skipFuncCheck() bool {
-	// compose CompletableFuture value type is unknown, function type check must be skipped.
-	return cf.vType == composeCfType
+// 阶段结果类型的零值，类型未知时为interface{}的零值
+func (cf *defaultCompletableFuture) zeroValue() reflect.Value {
+	if t := cf.valueType(); t != nil {
+		return reflect.New(t).Elem()
+	}
+	return reflect.Zero(functools.InterfaceType)
+}
+
+// 阶段结果的类型，ThenCompose返回的阶段在内部阶段结束前类型未知，返回nil
+// 类型未知时不在创建阶段时检查参数函数，由functools.Call在执行时检查参数类型
+func (cf *defaultCompletableFuture) valueType() reflect.Type {
+	return cf.v.Type()
+}
+
+// WhenComplete及Handle参数函数的panic参数，被取消时为*CancellationError
+func panicValue(ve ValueOrError) reflect.Value {
+	if ve.IsDone() {
+		return reflect.ValueOf(ve.GetCancellation())
+	}
+	if p := ve.GetPanic(); p != nil {
+		return reflect.ValueOf(p)
+	}
+	return reflect.Zero(functools.InterfaceType)
 }
 
 func handlePanic(handler *defaultValueHandler) {
@@ -1274,174 +1388,138 @@ func handlePanic(handler *defaultValueHandler) {
 	}
 }
 
-func convert(stage CompletionStage) *defaultCompletableFuture {
-	if v, ok := stage.(*defaultCompletableFuture); ok {
-		return v
-	}
-	panic("CompletionStage is not *defaultCompletableFuture")
+// 阶段函数参数及Get结果的类型转换模式
+func (cf *defaultCompletableFuture) convertMode() functools.ConvertMode {
+	return cf.convert
 }
 
-func (cf *defaultCompletableFuture) chooseExecutor(executor ...executor.Executor) executor.Executor {
-	ret := defaultExecutor
-	if len(executor) > 0 && executor[0] != nil {
-		ret = executor[0]
+func (cf *defaultCompletableFuture) getPanicPolicy() PanicPolicy {
+	if cf.panicPolicy != nil {
+		return *cf.panicPolicy
 	}
-	return ret
+	return cf.engine.getPanicPolicy()
 }
 
-func chooseExecutor(executor ...executor.Executor) executor.Executor {
-	if len(executor) == 0 {
-		return defaultExecutor
-	} else {
+func (cf *defaultCompletableFuture) chooseExecutor(executor ...executor.Executor) executor.Executor {
+	if len(executor) > 0 && executor[0] != nil {
 		return executor[0]
 	}
+	return cf.engine.Executor()
 }
 
-type composeCf struct {
-	joinVe Joinable
+func CompletedFuture(value interface{}, opts ...Option) (retCf CompletionStage) {
+	return defaultEngine.CompletedFuture(value, opts...)
 }
 
-var composeCfType = reflect.TypeOf((*composeCf)(nil))
-
-func CompletedFuture(value interface{}) (retCf CompletionStage) {
-	var v reflect.Value
-	var t reflect.Type
-	if value == nil {
-		v = functools.NilValue
-		t = functools.NilType
-	} else {
-		v = reflect.ValueOf(value)
-		t = v.Type()
-	}
+func SupplyAsync(f interface{}, executor ...executor.Executor) (retCf CompletionStage) {
+	return defaultEngine.SupplyAsync(f, executor...)
+}
 
-	vh := NewSyncHandler(t)
-	ctx, cancel := context.WithCancel(context.Background())
-	retCf = newCfWithCancel(ctx, cancel, vh)
+func RunAsync(f func(), executor ...executor.Executor) (retCf CompletionStage) {
+	return defaultEngine.RunAsync(f, executor...)
+}
 
-	defer retCf.(*defaultCompletableFuture).setDone()
+func AllOf(cfs ...CompletionStage) (retCf CompletionStage) {
+	return defaultEngine.AllOf(cfs...)
+}
 
-	err := vh.SetValue(v)
-	if err != nil {
-		vh.SetPanic(err)
-	}
-	return
+func AnyOf(cfs ...CompletionStage) (retCf CompletionStage) {
+	return defaultEngine.AnyOf(cfs...)
 }
 
-func SupplyAsync(f interface{}, executor ...executor.Executor) (retCf CompletionStage) {
-	fnValue := reflect.ValueOf(f)
-	if err := functools.CheckSupplyFunction(fnValue.Type()); err != nil {
-		panic(err)
-	}
+func AllOfWithOptions(cfs []CompletionStage, opts ...Option) (retCf CompletionStage) {
+	return defaultEngine.AllOfWithOptions(cfs, opts...)
+}
 
-	vh := NewAsyncHandler(fnValue.Type().Out(0))
-	ctx, cancel := context.WithCancel(context.Background())
-	retCf = newCfWithCancel(ctx, cancel, vh)
+func AnyOfWithOptions(cfs []CompletionStage, opts ...Option) (retCf CompletionStage) {
+	return defaultEngine.AnyOfWithOptions(cfs, opts...)
+}
 
-	exec := chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer retCf.(*defaultCompletableFuture).setDone()
+// 复制CompletionStage，新阶段与原阶段的结果相同
+// 新阶段可以独立完成或取消，不影响原阶段；原阶段被取消时新阶段也被取消
+func Copy(stage CompletionStage) (retCf CompletionStage) {
+	// 新阶段结束后不再等待外部实现的原阶段
+	detach := make(chan struct{})
+	cf := convert(defaultEngine, detach, stage)
+	cf.checkValue()
 
-		v := functools.RunSupply(fnValue)
-		err := vh.SetValue(v)
-		if err != nil {
-			vh.SetPanic(err)
-		}
+	vh := NewAsyncHandler(cf.valueType())
+	ctx, cancel := context.WithCancelCause(cf.ctx)
+	ret := newCfWithCancel(cf.engine, ctx, cancel, vh)
+	retCf = ret
+	vh.onComplete(func() {
+		close(detach)
 	})
-	if err != nil {
-		panic(err)
-	}
-	return
-}
 
-func RunAsync(f func(), executor ...executor.Executor) (retCf CompletionStage) {
-	vh := NewAsyncHandler(functools.NilType)
-	ctx, cancel := context.WithCancel(context.Background())
-	retCf = newCfWithCancel(ctx, cancel, vh)
-
-	exec := chooseExecutor(executor...)
-	err := exec.Run(func() {
-		defer handlePanic(vh)
-		defer retCf.(*defaultCompletableFuture).setDone()
-		f()
-		err := vh.SetValue(functools.NilValue)
-		if err != nil {
-			vh.SetPanic(err)
-		}
+	err := ret.submit(cf.chooseExecutor(), true, func(taskCtx context.Context) {
+		ve := cf.getValue(upstreamCtx(ctx, taskCtx))
+		vh.resolveType(cf.valueType())
+		vh.SetValueOrError(ve.Clone())
 	})
 	if err != nil {
-		panic(err)
+		vh.SetPanic(err)
 	}
 	return
 }
 
-func AllOf(cfs ...CompletionStage) (retCf CompletionStage) {
-	vh := NewSyncHandler(functools.NilType)
-	cancellers := make([]context.CancelFunc, 0, len(cfs))
-	vhs := make([]ValueHandler, 0, len(cfs))
-	for _, cf := range cfs {
-		vhs = append(vhs, cf.(*defaultCompletableFuture).v)
-		cancellers = append(cancellers, cf.(*defaultCompletableFuture).cancelFunc)
-	}
-	ctx, cancel := context.WithCancel(context.Background())
-	retCf = newCfWithCancel(ctx, func() {
-		cancel()
-		for _, cancelFunc := range cancellers {
-			cancelFunc()
-		}
-	}, vh)
+var completionStageType = reflect.TypeOf((*CompletionStage)(nil)).Elem()
 
-	rets := AllOfValue(ctx, vhs...)
-	for _, v := range rets {
-		if v.HavePanic() {
-			panic(v.GetPanic())
-		}
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// ThenCompose的参数函数以context.Context为第一个参数时，返回去掉该参数后的函数类型
+func composeFuncType(fn reflect.Type) (reflect.Type, bool) {
+	if fn.Kind() != reflect.Func || fn.NumIn() == 0 || fn.In(0) != contextType {
+		return fn, false
 	}
-	err := vh.SetValue(functools.NilValue)
-	if err != nil {
-		vh.SetPanic(err)
+	in := make([]reflect.Type, 0, fn.NumIn()-1)
+	for i := 1; i < fn.NumIn(); i++ {
+		in = append(in, fn.In(i))
 	}
-	return
-}
-
-func AnyOf(cfs ...CompletionStage) (retCf CompletionStage) {
-	vh := NewSyncHandler(functools.NilType)
-	vhs := make([]ValueHandler, 0, len(cfs))
-	for _, cf := range cfs {
-		vhs = append(vhs, cf.(*defaultCompletableFuture).v)
+	out := make([]reflect.Type, 0, fn.NumOut())
+	for i := 0; i < fn.NumOut(); i++ {
+		out = append(out, fn.Out(i))
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	retCf = newCfWithCancel(ctx, func() {
-		cancel()
-	}, vh)
+	return reflect.FuncOf(in, out, fn.IsVariadic()), true
+}
 
-	_, ve := AnyOfValue(ctx, vhs...)
-	if ve.HavePanic() {
-		panic(ve.GetPanic())
+// 将ctx绑定为参数函数的第一个参数，参数函数没有context.Context参数时直接返回
+func bindContext(fn reflect.Value, ctx context.Context) reflect.Value {
+	t, ok := composeFuncType(fn.Type())
+	if !ok {
+		return fn
 	}
-	err := vh.SetValue(functools.NilValue)
-	if err != nil {
-		vh.SetPanic(err)
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	return
+	ctxValue := reflect.ValueOf(&ctx).Elem()
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		args = append([]reflect.Value{ctxValue}, args...)
+		if t.IsVariadic() {
+			return fn.CallSlice(args)
+		}
+		return fn.Call(args)
+	})
 }
 
-var completionStageType = reflect.TypeOf((*CompletionStage)(nil)).Elem()
-
 type Joinable interface {
 	JoinCompletionStage(ctx context.Context) CompletionStage
 }
 
-func checkComposeFunction(fn reflect.Type, vType reflect.Type) error {
+func checkComposeFunction(mode functools.ConvertMode, fn reflect.Type, vType reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 1 || fn.NumOut() != 1 {
+	fn, _ = composeFuncType(fn)
+	if fn.NumOut() != 1 {
 		return errors.New("Type must be f func(o TYPE) CompletionStage. number not match. ")
 	}
-	inType := fn.In(0)
-	if inType != vType {
-		return errors.New("Type must be f func(o TYPE) CompletionStage. in[0] not match. ")
+	if vType != nil && !mode.SpreadMatch(fn, vType) {
+		if !functools.NumInMatch(fn, 1) {
+			return errors.New("Type must be f func(o TYPE) CompletionStage. number not match. ")
+		}
+		if !mode.InMatch(fn, 0, vType) {
+			return errors.New("Type must be f func(o TYPE) CompletionStage. in[0] not match. ")
+		}
 	}
 
 	outType := fn.Out(0)
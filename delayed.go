@@ -0,0 +1,152 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"github.com/xfali/executor"
+	"sync"
+	"time"
+)
+
+// 延迟delay后再将任务提交到实际执行的协程池，实现ContextExecutor及InheritableExecutor
+type delayedExecutor struct {
+	engine *Engine
+	clock  Clock
+	delay  time.Duration
+	// 实际执行的协程池，未指定时为nil，使用引擎的协程池
+	exec executor.Executor
+	// 后续阶段继承的协程池，未指定实际执行的协程池时为nil
+	inherit executor.Executor
+
+	lock    sync.Mutex
+	stopped bool
+	// 等待提交的任务，任务从中移除后由移除者负责提交或拒绝
+	pending map[*delayedTask]struct{}
+}
+
+type delayedTask struct {
+	ctx  context.Context
+	task executor.Task
+	stop func() bool
+}
+
+// 返回延迟delay后再将任务提交到executor的协程池，延迟使用引擎的时钟
+// 只延迟当前阶段，后续阶段继承实际执行的协程池
+// Stop丢弃尚未提交的任务，其阶段以ErrPoolStopped拒绝；不会停止实际执行的协程池
+// Param：delay 延迟时间
+// Param：Executor: 实际执行的协程池，如果不填则使用到期时引擎的协程池
+func (e *Engine) DelayedExecutor(delay time.Duration, executor ...executor.Executor) executor.Executor {
+	ret := &delayedExecutor{
+		engine:  e,
+		clock:   e.Clock(),
+		delay:   delay,
+		pending: map[*delayedTask]struct{}{},
+	}
+	if len(executor) > 0 && executor[0] != nil {
+		checkExecutor(executor[0])
+		ret.exec = executor[0]
+		ret.inherit = executor[0]
+	}
+	return ret
+}
+
+func (d *delayedExecutor) Run(task executor.Task) error {
+	return d.RunContext(context.Background(), task)
+}
+
+func (d *delayedExecutor) RunContext(ctx context.Context, task executor.Task) error {
+	t := &delayedTask{
+		ctx:  ctx,
+		task: task,
+	}
+	d.lock.Lock()
+	if d.stopped {
+		d.lock.Unlock()
+		return &RejectedError{Cause: ErrPoolStopped}
+	}
+	d.pending[t] = struct{}{}
+	d.lock.Unlock()
+
+	// 时钟可能在AfterFunc中同步触发，不能持有锁
+	stop := d.clock.AfterFunc(d.delay, func() {
+		if d.take(t) {
+			d.submit(t)
+		}
+	})
+	d.lock.Lock()
+	t.stop = stop
+	d.lock.Unlock()
+	return nil
+}
+
+// 从等待提交的任务中移除t，已被移除时返回false
+func (d *delayedExecutor) take(t *delayedTask) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.pending[t]; !ok {
+		return false
+	}
+	delete(d.pending, t)
+	return true
+}
+
+// 将到期的任务提交到实际执行的协程池，提交失败时以该错误拒绝任务
+func (d *delayedExecutor) submit(t *delayedTask) {
+	if t.ctx.Err() != nil {
+		dropTask(t.ctx)
+		return
+	}
+	exec := d.exec
+	if exec == nil {
+		exec = d.engine.Executor()
+	}
+	var err error
+	if ce, ok := exec.(ContextExecutor); ok {
+		err = ce.RunContext(t.ctx, t.task)
+	} else {
+		err = exec.Run(t.task)
+	}
+	if err != nil {
+		RejectTask(t.ctx, err)
+	}
+}
+
+// 只延迟当前阶段，后续阶段继承实际执行的协程池
+func (d *delayedExecutor) Inherit() executor.Executor {
+	return d.inherit
+}
+
+// 停止延迟：拒绝新的任务，停止定时器并丢弃尚未提交的任务
+func (d *delayedExecutor) Stop() {
+	d.lock.Lock()
+	d.stopped = true
+	pending := make([]delayedTask, 0, len(d.pending))
+	for t := range d.pending {
+		pending = append(pending, *t)
+	}
+	d.pending = map[*delayedTask]struct{}{}
+	d.lock.Unlock()
+
+	// 定时器尚未设置时，到期后任务已不在等待列表中，不会被提交
+	for _, t := range pending {
+		if t.stop != nil {
+			t.stop()
+		}
+		RejectTask(t.ctx, ErrPoolStopped)
+	}
+}
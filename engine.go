@@ -0,0 +1,520 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable/functools"
+	"github.com/xfali/executor"
+	"log"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// 阶段函数panic时Get的行为
+type PanicPolicy int
+
+const (
+	// Get时重新抛出panic（默认）
+	PanicPropagate PanicPolicy = iota
+	// Get时不抛出panic，返回*PanicError
+	PanicAsError
+)
+
+// 引擎的钩子函数，字段为nil时不调用
+type Hooks struct {
+	// 异步任务提交到协程池前调用
+	OnSubmit func(stage CompletionStage)
+	// 阶段设置结果后调用（包括正常结束、panic及取消）
+	OnComplete func(stage CompletionStage, ve ValueOrError)
+}
+
+// 时钟，用于Get的超时等待，测试时可以替换
+type Clock interface {
+	// 当前时间
+	Now() time.Time
+
+	// d时间后执行f，返回的函数用于停止
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+type realClock struct{}
+
+func (c realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+var ErrEngineShutdown = errors.New("Engine is shutdown. ")
+
+// 运行时引擎，持有协程池、panic日志、panic策略、钩子及时钟
+// 由引擎创建的阶段及其后续阶段都绑定到该引擎，不同引擎的配置互不影响
+// 包级别的函数（SupplyAsync、SetDefaultExecutor等）使用默认引擎
+type Engine struct {
+	lock        sync.RWMutex
+	executor    executor.Executor
+	logger      func(s []byte)
+	allStacks   bool
+	panicPolicy PanicPolicy
+	hooks       Hooks
+	clock       Clock
+	// 后续的*Async阶段未指定协程池时是否继承上一阶段的协程池
+	noInherit bool
+	// 阶段函数参数及Get结果的类型转换模式
+	convertMode functools.ConvertMode
+	// 阶段的取消模式
+	cancelMode CancelMode
+
+	// 已提交未结束的异步任务
+	inflight sync.WaitGroup
+	shutdown bool
+}
+
+type EngineOpt func(e *Engine)
+
+// 设置引擎的协程池，默认为UnlimitedExecutor
+func EngineExecutor(exec executor.Executor) EngineOpt {
+	checkExecutor(exec)
+	return func(e *Engine) {
+		e.executor = exec
+	}
+}
+
+// 设置panic堆栈的输出函数，f为nil时不记录堆栈，all为true时记录所有协程的堆栈
+func EngineLogPanicStacks(f func(s []byte), all bool) EngineOpt {
+	return func(e *Engine) {
+		e.logger = f
+		e.allStacks = all
+	}
+}
+
+// 设置panic策略，默认为PanicPropagate
+func EnginePanicPolicy(policy PanicPolicy) EngineOpt {
+	return func(e *Engine) {
+		e.panicPolicy = policy
+	}
+}
+
+// 设置后续的*Async阶段未指定协程池时是否继承上一阶段的协程池，默认继承
+// 不继承时与旧版本行为相同，使用引擎的协程池
+func EngineInheritExecutor(inherit bool) EngineOpt {
+	return func(e *Engine) {
+		e.noInherit = !inherit
+	}
+}
+
+// 设置阶段函数参数及Get结果的类型转换模式，默认为functools.DefaultConvertMode
+func EngineConvertMode(mode functools.ConvertMode) EngineOpt {
+	return func(e *Engine) {
+		e.convertMode = mode
+	}
+}
+
+// 设置阶段的取消模式，默认为CancelBranch
+func EngineCancelMode(mode CancelMode) EngineOpt {
+	return func(e *Engine) {
+		e.cancelMode = mode
+	}
+}
+
+// 设置钩子函数
+func EngineHooks(hooks Hooks) EngineOpt {
+	return func(e *Engine) {
+		e.hooks = hooks
+	}
+}
+
+// 设置时钟，默认为系统时钟
+func EngineClock(clock Clock) EngineOpt {
+	return func(e *Engine) {
+		e.clock = clock
+	}
+}
+
+// 创建引擎
+func NewEngine(opts ...EngineOpt) *Engine {
+	ret := &Engine{
+		executor: &UnlimitedExecutor{},
+		logger: func(s []byte) {
+			log.Print(string(s))
+		},
+		clock:       realClock{},
+		convertMode: functools.DefaultConvertMode,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.executor == nil {
+		ret.executor = &UnlimitedExecutor{}
+	}
+	if ret.clock == nil {
+		ret.clock = realClock{}
+	}
+	return ret
+}
+
+var defaultEngine = NewEngine()
+
+// 获得默认引擎，包级别的函数均使用该引擎
+func DefaultEngine() *Engine {
+	return defaultEngine
+}
+
+// 设置引擎的协程池，只影响之后提交的任务
+func (e *Engine) SetExecutor(exec executor.Executor) {
+	checkExecutor(exec)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.executor = exec
+}
+
+// 设置panic堆栈的输出函数，f为nil时不记录堆栈，all为true时记录所有协程的堆栈
+func (e *Engine) SetLogPanicStacks(f func(s []byte), all bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.logger = f
+	e.allStacks = all
+}
+
+// 设置后续的*Async阶段未指定协程池时是否继承上一阶段的协程池
+func (e *Engine) SetInheritExecutor(inherit bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.noInherit = !inherit
+}
+
+// 后续的*Async阶段未指定协程池时是否继承上一阶段的协程池
+func (e *Engine) InheritExecutor() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return !e.noInherit
+}
+
+// 设置阶段函数参数及Get结果的类型转换模式，只影响之后创建的阶段
+func (e *Engine) SetConvertMode(mode functools.ConvertMode) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.convertMode = mode
+}
+
+// 获得阶段函数参数及Get结果的类型转换模式
+func (e *Engine) ConvertMode() functools.ConvertMode {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.convertMode
+}
+
+// 设置阶段的取消模式，只影响之后创建的阶段
+func (e *Engine) SetCancelMode(mode CancelMode) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.cancelMode = mode
+}
+
+// 获得阶段的取消模式
+func (e *Engine) CancelMode() CancelMode {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.cancelMode
+}
+
+// 获得引擎的协程池
+func (e *Engine) Executor() executor.Executor {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.executor
+}
+
+// 获得引擎的时钟
+func (e *Engine) Clock() Clock {
+	return e.clock
+}
+
+// 关闭引擎：之后提交的异步任务返回ErrEngineShutdown，并等待已提交的任务结束
+// 不会停止引擎的协程池，协程池由创建者负责停止
+// ctx结束时停止等待并返回ctx.Err()
+func (e *Engine) Shutdown(ctx context.Context) error {
+	e.lock.Lock()
+	e.shutdown = true
+	e.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 登记一个异步任务，引擎已关闭时返回false
+func (e *Engine) acquire() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.shutdown {
+		return false
+	}
+	e.inflight.Add(1)
+	return true
+}
+
+func (e *Engine) release() {
+	e.inflight.Done()
+}
+
+func (e *Engine) getPanicPolicy() PanicPolicy {
+	return e.panicPolicy
+}
+
+func (e *Engine) logPanic(s []byte) {
+	e.lock.RLock()
+	logger := e.logger
+	e.lock.RUnlock()
+	if logger != nil {
+		logger(s)
+	}
+}
+
+func (e *Engine) stacks() []byte {
+	e.lock.RLock()
+	logger, all := e.logger, e.allStacks
+	e.lock.RUnlock()
+	if logger == nil {
+		return nil
+	}
+
+	n := 10240
+	if all {
+		n = 102400
+	}
+	var trace []byte
+	var i uint8 = 0
+	for ; i < 5; i++ {
+		trace = make([]byte, n<<i)
+		nbytes := runtime.Stack(trace, all)
+		if nbytes < len(trace) {
+			return trace[:nbytes]
+		}
+	}
+	return trace
+}
+
+// 创建绑定到引擎的阶段
+func (e *Engine) newStage(vh *defaultValueHandler) *defaultCompletableFuture {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return newCfWithCancel(e, ctx, cancel, vh)
+}
+
+// 创建已完成的CompletionStage
+// Param：opts 阶段选项，指定的协程池由后续阶段继承
+func (e *Engine) CompletedFuture(value interface{}, opts ...Option) (retCf CompletionStage) {
+	var v reflect.Value
+	var t reflect.Type
+	if value == nil {
+		v = functools.NilValue
+		t = functools.NilType
+	} else {
+		v = reflect.ValueOf(value)
+		t = v.Type()
+	}
+
+	vh := NewSyncHandler(t)
+	ret := e.newStage(vh)
+	retCf = ret
+	ret.applyRootOptions(opts)
+
+	err := vh.SetValue(v)
+	if err != nil {
+		vh.SetPanic(err)
+	}
+	return
+}
+
+// 异步执行有返回值的函数
+// Param：f func() TYPE
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用引擎的协程池
+// 协程池拒绝任务时阶段按拒绝策略处理，默认以ErrRejected异常结束
+func (e *Engine) SupplyAsync(f interface{}, executor ...executor.Executor) (retCf CompletionStage) {
+	fnValue := reflect.ValueOf(f)
+	if err := functools.CheckSupplyFunction(fnValue.Type()); err != nil {
+		panic(err)
+	}
+
+	vh := NewAsyncHandler(functools.OutType(fnValue.Type()))
+	ret := e.newStage(vh)
+	retCf = ret
+
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(context.Context) {
+		v := functools.RunSupply(fnValue)
+		err := vh.SetValue(v)
+		if err != nil {
+			vh.SetPanic(err)
+		}
+	})
+	if err != nil {
+		vh.SetPanic(err)
+	}
+	return
+}
+
+// 异步执行无返回值的函数
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用引擎的协程池
+// 协程池拒绝任务时阶段按拒绝策略处理，默认以ErrRejected异常结束
+func (e *Engine) RunAsync(f func(), executor ...executor.Executor) (retCf CompletionStage) {
+	vh := NewAsyncHandler(functools.NilType)
+	ret := e.newStage(vh)
+	retCf = ret
+
+	exec := ret.applyOptions(executor...)
+	err := ret.submit(exec, true, func(context.Context) {
+		f()
+		err := vh.SetValue(functools.NilValue)
+		if err != nil {
+			vh.SetPanic(err)
+		}
+	})
+	if err != nil {
+		vh.SetPanic(err)
+	}
+	return
+}
+
+// 所有CompletionStage都完成后完成
+func (e *Engine) AllOf(cfs ...CompletionStage) (retCf CompletionStage) {
+	return e.AllOfWithOptions(cfs)
+}
+
+// 所有CompletionStage都完成后完成，opts在等待前应用，WithTimeout及WithContext可以结束等待
+func (e *Engine) AllOfWithOptions(cfs []CompletionStage, opts ...Option) (retCf CompletionStage) {
+	vh := NewSyncHandler(functools.NilType)
+	cancellers := make([]context.CancelCauseFunc, 0, len(cfs))
+	vhs := make([]ValueHandler, 0, len(cfs))
+	for _, cf := range cfs {
+		dcf := convert(e, nil, cf)
+		vhs = append(vhs, dcf.v)
+		cancellers = append(cancellers, dcf.cancelFunc)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	// CancelChain模式下取消AllOf同时取消所有参数阶段
+	chain := e.CancelMode() == CancelChain
+	ret := newCfWithCancel(e, ctx, func(cause error) {
+		cancel(cause)
+		if !chain {
+			return
+		}
+		for _, cancelFunc := range cancellers {
+			cancelFunc(cause)
+		}
+	}, vh)
+	retCf = ret
+	ret.applyRootOptions(opts)
+
+	rets := AllOfValue(ret.ctx, vhs...)
+	for _, v := range rets {
+		if v.HavePanic() {
+			panic(v.GetPanic())
+		}
+	}
+	// 记录触发取消的参数阶段
+	for i, v := range rets {
+		if v.IsDone() {
+			err := newCancellationError(cancellationCause(v.GetCancellation()), i)
+			vh.setCancel(err)
+			cancel(err)
+			return
+		}
+	}
+	err := vh.SetValue(functools.NilValue)
+	if err != nil {
+		vh.SetPanic(err)
+	}
+	return
+}
+
+// 任意CompletionStage完成后完成
+func (e *Engine) AnyOf(cfs ...CompletionStage) (retCf CompletionStage) {
+	return e.AnyOfWithOptions(cfs)
+}
+
+// 任意CompletionStage完成后完成，opts在等待前应用，WithTimeout及WithContext可以结束等待
+func (e *Engine) AnyOfWithOptions(cfs []CompletionStage, opts ...Option) (retCf CompletionStage) {
+	vh := NewSyncHandler(functools.NilType)
+	vhs := make([]ValueHandler, 0, len(cfs))
+	// 已有阶段完成后不再等待其余外部实现的阶段
+	detach := make(chan struct{})
+	defer close(detach)
+	for _, cf := range cfs {
+		vhs = append(vhs, convert(e, detach, cf).v)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	ret := newCfWithCancel(e, ctx, cancel, vh)
+	retCf = ret
+	ret.applyRootOptions(opts)
+
+	index, ve := AnyOfValue(ret.ctx, vhs...)
+	if ve.HavePanic() {
+		panic(ve.GetPanic())
+	}
+	// 记录触发取消的参数阶段
+	if ve.IsDone() {
+		err := newCancellationError(cancellationCause(ve.GetCancellation()), index)
+		vh.setCancel(err)
+		cancel(err)
+		return
+	}
+	err := vh.SetValue(functools.NilValue)
+	if err != nil {
+		vh.SetPanic(err)
+	}
+	return
+}
+
+// 创建未完成的CompletionStage，由返回的Resolver完成
+// Param：t 阶段结果类型，为nil时为interface{}
+// Param：opts 阶段选项，指定的协程池由后续阶段继承
+func (e *Engine) NewCompletableFuture(t reflect.Type, opts ...Option) (CompletionStage, Resolver) {
+	if t == nil {
+		t = functools.InterfaceType
+	}
+	return e.newResolvable(t, opts)
+}
+
+// 创建结果类型未知的CompletionStage，由返回的Resolver完成，参考NewPromise
+func (e *Engine) NewPromise(opts ...Option) (CompletionStage, Resolver) {
+	return e.newResolvable(nil, opts)
+}
+
+func (e *Engine) newResolvable(t reflect.Type, opts []Option) (CompletionStage, Resolver) {
+	vh := NewAsyncHandler(t)
+	cf := e.newStage(vh)
+	cf.applyRootOptions(opts)
+	return cf, &defaultResolver{
+		cf: cf,
+		vh: vh,
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Get的目标参数不是非空指针
+type InvalidResultError struct {
+	// 目标参数类型，目标参数为nil时为nil
+	Type reflect.Type
+}
+
+func (e *InvalidResultError) Error() string {
+	if e.Type == nil {
+		return "Result must be a non-nil pointer, got nil. "
+	}
+	return fmt.Sprintf("Result must be a non-nil pointer, got %s . ", e.Type.String())
+}
+
+// 阶段结果无法设置到Get的目标参数
+type ResultTypeError struct {
+	// 阶段结果类型
+	ValueType reflect.Type
+	// 目标参数指向的类型
+	ResultType reflect.Type
+}
+
+func (e *ResultTypeError) Error() string {
+	return fmt.Sprintf("Cannot set value of type %s to result of type %s . ", e.ValueType.String(), e.ResultType.String())
+}
+
+// 对只读CompletionStage执行完成或取消操作
+type ReadOnlyError struct {
+	// 被拒绝的操作
+	Op string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("CompletionStage is read-only, %s is not supported. ", e.Op)
+}
+
+// 阶段被取消，Get返回该错误，Handle及WhenComplete的panic参数为该错误
+type CancellationError struct {
+	// 取消原因，未指定原因时为context.Canceled，WithTimeout超时为context.DeadlineExceeded
+	Cause error
+	// AllOf、AnyOf中触发取消的参数阶段序号，其他情况为-1
+	Index int
+}
+
+func newCancellationError(cause error, index int) *CancellationError {
+	if cause == nil {
+		cause = context.Canceled
+	}
+	return &CancellationError{
+		Cause: cause,
+		Index: index,
+	}
+}
+
+func (e *CancellationError) Error() string {
+	if e.Index >= 0 {
+		return fmt.Sprintf("cancelled by stage %d: %v. ", e.Index, e.Cause)
+	}
+	return fmt.Sprintf("cancelled: %v. ", e.Cause)
+}
+
+func (e *CancellationError) Unwrap() error {
+	return e.Cause
+}
+
+// 参数阶段的取消原因，参数阶段未被取消时为nil
+func cancellationCause(e *CancellationError) error {
+	if e == nil {
+		return nil
+	}
+	return e.Cause
+}
+
+// 带超时时间的Get等待超时，阶段状态不变，errors.Is(err, context.DeadlineExceeded)为true
+type TimeoutError struct {
+	// Get的超时时间
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Get timeout after %v. ", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Get超时时取消等待的原因，与阶段自身的取消原因区分
+var errGetTimeout = errors.New("Get timeout. ")
+
+// PanicAsError策略下Get返回的错误，包含阶段函数的panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v. ", e.Value)
+}
+
+// panic值为error时返回该error
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"github.com/xfali/executor"
+	"sync/atomic"
+)
+
+// 支持context的协程池扩展接口
+// 阶段的任务通过RunContext提交，ctx被取消时协程池可以直接丢弃尚未执行的任务，不占用工作协程
+// 丢弃任务是安全的：ctx被取消时阶段已被设置为取消状态
+type ContextExecutor interface {
+	executor.Executor
+
+	// 执行一个任务，ctx为任务所属阶段的context
+	RunContext(ctx context.Context, task executor.Task) error
+}
+
+type rejectKey struct{}
+
+// 阶段任务被协程池丢弃时的回调
+type taskCallbacks struct {
+	reject  func(err error)
+	discard func()
+	// 任务未执行即被丢弃时调用，供协程池统计使用
+	drop func()
+	// 执行任务的协程池，复制回调时共享
+	owner *taskOwner
+}
+
+// 任务未执行即被丢弃（拒绝、丢弃或阶段已取消）
+func dropTask(ctx context.Context) {
+	if cb, ok := ctx.Value(rejectKey{}).(*taskCallbacks); ok && cb.drop != nil {
+		cb.drop()
+	}
+}
+
+// 在ctx的回调中增加任务被丢弃时的处理
+func withDropHook(ctx context.Context, f func()) context.Context {
+	ret := &taskCallbacks{
+		reject:  func(err error) {},
+		discard: func() {},
+	}
+	if cb, ok := ctx.Value(rejectKey{}).(*taskCallbacks); ok {
+		*ret = *cb
+	}
+	prev := ret.drop
+	ret.drop = func() {
+		f()
+		if prev != nil {
+			prev()
+		}
+	}
+	return context.WithValue(ctx, rejectKey{}, ret)
+}
+
+// 协程池拒绝已接受（Run返回nil）但尚未执行的任务时调用，ctx为RunContext传入的context
+// 任务所属的阶段以err（包装为*RejectedError）异常结束，避免阶段永远无法结束
+// ctx不是阶段任务的context时不做任何操作
+func RejectTask(ctx context.Context, err error) {
+	if ctx == nil {
+		return
+	}
+	if cb, ok := ctx.Value(rejectKey{}).(*taskCallbacks); ok {
+		cb.reject(err)
+		dropTask(ctx)
+	}
+}
+
+// 协程池按DiscardPolicy或DiscardOldestPolicy丢弃已接受的任务时调用
+// 任务所属的阶段以ErrRejected为原因被取消；ctx不是阶段任务的context时不做任何操作
+func DiscardTask(ctx context.Context) {
+	if ctx == nil {
+		return
+	}
+	if cb, ok := ctx.Value(rejectKey{}).(*taskCallbacks); ok {
+		cb.discard()
+		dropTask(ctx)
+	}
+}
+
+// 可以指定后续阶段继承的协程池的扩展接口
+// 例如延迟执行的协程池只延迟当前阶段，后续阶段继承实际执行任务的协程池
+type InheritableExecutor interface {
+	executor.Executor
+
+	// 返回后续阶段继承的协程池，为nil时后续阶段使用引擎的协程池
+	Inherit() executor.Executor
+}
+
+func inheritedExecutor(exec executor.Executor) executor.Executor {
+	if ie, ok := exec.(InheritableExecutor); ok {
+		return ie.Inherit()
+	}
+	return exec
+}
+
+// 提交阶段的异步任务
+// 1、ctx被取消时立即将阶段设置为取消状态，不等待任务执行
+// 2、checkCancel为true时，执行用户代码前检查ctx，已取消则不执行
+// （WhenComplete及Handle需要获得上一阶段的取消错误，不检查）
+// 3、协程池实现ContextExecutor时使用RunContext提交
+// 4、任务登记到所属引擎，引擎关闭后返回ErrEngineShutdown
+// 尚未开始执行时阶段被取消的任务视为已结束（协程池可能直接丢弃）
+// 5、协程池通过RejectTask丢弃已接受的任务时，阶段以该错误异常结束，通过DiscardTask丢弃时阶段被取消
+// 6、协程池拒绝任务时按阶段的RejectionPolicy处理，PolicyExecutor由协程池自身处理
+// 7、阶段指定了优先级时，协程池可通过TaskPriority从ctx获得
+// 8、task的参数为任务的ctx，任务中等待上一阶段时通过upstreamCtx使用
+func (cf *defaultCompletableFuture) submit(exec executor.Executor, checkCancel bool, task func(taskCtx context.Context)) error {
+	e := cf.engine
+	if !e.acquire() {
+		return ErrEngineShutdown
+	}
+	if hook := e.hooks.OnSubmit; hook != nil {
+		hook(cf)
+	}
+	vh := cf.handler()
+	ctx := cf.ctx
+	// 0：等待执行，1：已开始执行，2：执行前被取消
+	var state int32
+	// 提交前设置，任务执行时使用
+	var taskCtx context.Context
+	cancel := func() {
+		vh.setCancel(newCancellationError(context.Cause(ctx), -1))
+		if atomic.CompareAndSwapInt32(&state, 0, 2) {
+			e.release()
+		}
+	}
+	stop := context.AfterFunc(ctx, cancel)
+	run := func() {
+		if atomic.CompareAndSwapInt32(&state, 0, 1) {
+			defer e.release()
+		}
+		defer stop()
+		defer handlePanic(vh)
+		if checkCancel && ctx.Err() != nil {
+			cancel()
+			return
+		}
+		vh.setRunning()
+		task(taskCtx)
+	}
+
+	cb := &taskCallbacks{
+		reject: func(err error) {
+			if atomic.CompareAndSwapInt32(&state, 0, 2) {
+				stop()
+				vh.SetPanic(newRejectedError(err))
+				e.release()
+			}
+		},
+		discard: func() {
+			if atomic.CompareAndSwapInt32(&state, 0, 2) {
+				stop()
+				vh.setCancel(newCancellationError(ErrRejected, -1))
+				e.release()
+				cf.cancelFunc(ErrRejected)
+			}
+		},
+		owner: &taskOwner{},
+	}
+	taskCtx = context.WithValue(ctx, rejectKey{}, cb)
+	if cf.priority != nil {
+		taskCtx = context.WithValue(taskCtx, priorityKey{}, *cf.priority)
+	}
+
+	var err error
+	switch ex := exec.(type) {
+	case PolicyExecutor:
+		err = ex.RunPolicy(taskCtx, run, cf.rejection)
+	case ContextExecutor:
+		err = ex.RunContext(taskCtx, run)
+		if err != nil {
+			err = applyRejection(taskCtx, cf.rejection, err, func() error {
+				return ex.RunContext(taskCtx, run)
+			}, run, cb.discard)
+		}
+	default:
+		err = exec.Run(run)
+		if err != nil {
+			err = applyRejection(taskCtx, cf.rejection, err, func() error {
+				return exec.Run(run)
+			}, run, cb.discard)
+		}
+	}
+	if err != nil {
+		stop()
+		if atomic.CompareAndSwapInt32(&state, 0, 2) {
+			e.release()
+		}
+		return newRejectedError(err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"github.com/xfali/executor"
+	"sync/atomic"
+)
+
+// 创建CompletionStage的工厂，用于切换不同实现（默认、lazycompletable、queued）
+type Factory interface {
+	// 创建已完成的CompletionStage
+	CompletedFuture(value interface{}) CompletionStage
+
+	// 异步执行有返回值的函数
+	SupplyAsync(f interface{}, executor ...executor.Executor) CompletionStage
+
+	// 异步执行无返回值的函数
+	RunAsync(f func(), executor ...executor.Executor) CompletionStage
+
+	// 所有CompletionStage都完成后完成
+	AllOf(cfs ...CompletionStage) CompletionStage
+
+	// 任意CompletionStage完成后完成
+	AnyOf(cfs ...CompletionStage) CompletionStage
+}
+
+type defaultFactory struct{}
+
+// 默认实现的工厂
+var DefaultFactory Factory = defaultFactory{}
+
+type factoryHolder struct {
+	f Factory
+}
+
+var gFactory atomic.Value
+
+func init() {
+	gFactory.Store(factoryHolder{f: DefaultFactory})
+}
+
+// 设置全局默认工厂，为nil时恢复为DefaultFactory
+func SetFactory(f Factory) {
+	if f == nil {
+		f = DefaultFactory
+	}
+	gFactory.Store(factoryHolder{f: f})
+}
+
+// 获得全局默认工厂
+func GetFactory() Factory {
+	return gFactory.Load().(factoryHolder).f
+}
+
+func (f defaultFactory) CompletedFuture(value interface{}) CompletionStage {
+	return CompletedFuture(value)
+}
+
+func (f defaultFactory) SupplyAsync(fn interface{}, executor ...executor.Executor) CompletionStage {
+	return SupplyAsync(fn, executor...)
+}
+
+func (f defaultFactory) RunAsync(fn func(), executor ...executor.Executor) CompletionStage {
+	return RunAsync(fn, executor...)
+}
+
+func (f defaultFactory) AllOf(cfs ...CompletionStage) CompletionStage {
+	return AllOf(cfs...)
+}
+
+func (f defaultFactory) AnyOf(cfs ...CompletionStage) CompletionStage {
+	return AnyOf(cfs...)
+}
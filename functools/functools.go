@@ -18,6 +18,7 @@ package functools
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 )
 
@@ -30,39 +31,207 @@ var (
 	InterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
 )
 
+type ConvertMode int32
+
+const (
+	// 仅允许可赋值的参数类型（包括实现了参数接口的类型）
+	ConvertAssignable ConvertMode = iota
+	// 在ConvertAssignable的基础上，允许相同Kind的可转换类型，如：type MyInt int 与 int
+	ConvertSameKind
+	// 允许所有reflect可转换的类型，如：int 与 float64
+	// 不包括改变值含义的转换：整数到字符串（按码点转换，65转换为"A"）及字符串与[]byte、[]rune之间的转换
+	ConvertAll
+)
+
+// 包级别的检查及调用函数使用的转换模式，阶段使用所属引擎的转换模式
+const DefaultConvertMode = ConvertSameKind
+
+// 类型from的值是否可以作为类型to的参数（使用DefaultConvertMode）
+func TypeMatch(from, to reflect.Type) bool {
+	return DefaultConvertMode.TypeMatch(from, to)
+}
+
+// 类型from的值是否可以作为类型to的参数
+func (m ConvertMode) TypeMatch(from, to reflect.Type) bool {
+	if from == to || from.AssignableTo(to) {
+		return true
+	}
+	if to == TupleType && IsTuple(from) {
+		return true
+	}
+	switch m {
+	case ConvertSameKind:
+		return from.Kind() == to.Kind() && from.ConvertibleTo(to)
+	case ConvertAll:
+		return from.ConvertibleTo(to) && !reinterpret(from, to)
+	}
+	return false
+}
+
+// 是否为改变值含义的转换：整数到字符串，字符串与[]byte、[]rune之间
+func reinterpret(from, to reflect.Type) bool {
+	switch to.Kind() {
+	case reflect.String:
+		switch from.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Slice:
+			return true
+		}
+	case reflect.Slice:
+		return from.Kind() == reflect.String
+	}
+	return false
+}
+
+// 将值转换为类型t（使用DefaultConvertMode）
+func ConvertValue(v reflect.Value, t reflect.Type) reflect.Value {
+	return DefaultConvertMode.ConvertValue(v, t)
+}
+
+// 将值转换为类型t，NilType的值转换为t的零值，接口类型的值使用其动态值转换
+func (m ConvertMode) ConvertValue(v reflect.Value, t reflect.Type) reflect.Value {
+	if !v.IsValid() || v.Type() == NilType {
+		return reflect.Zero(t)
+	}
+	vt := v.Type()
+	if vt == t || vt.AssignableTo(t) {
+		return v
+	}
+	if t == TupleType && IsTuple(vt) {
+		return reflect.ValueOf(ToTuple(v))
+	}
+	if vt.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Zero(t)
+		}
+		return m.ConvertValue(v.Elem(), t)
+	}
+	if m.TypeMatch(vt, t) {
+		return v.Convert(t)
+	}
+	return v
+}
+
+// 函数是否可以使用n个参数调用（支持变长参数）
+func NumInMatch(fn reflect.Type, n int) bool {
+	if fn.IsVariadic() {
+		return n >= fn.NumIn()-1
+	}
+	return fn.NumIn() == n
+}
+
+// 使用DefaultConvertMode，参考ConvertMode.InMatch
+func InMatch(fn reflect.Type, i int, vType reflect.Type) bool {
+	return DefaultConvertMode.InMatch(fn, i, vType)
+}
+
+// 类型为vType的值是否可以作为函数的第i个实参
+// 变长参数位置既可以匹配元素类型，也可以匹配切片类型本身（此时展开传递）
+func (m ConvertMode) InMatch(fn reflect.Type, i int, vType reflect.Type) bool {
+	if vType == NilType {
+		return true
+	}
+	last := fn.NumIn() - 1
+	if fn.IsVariadic() && i >= last {
+		if i == last && vType == fn.In(last) {
+			return true
+		}
+		return m.TypeMatch(vType, fn.In(last).Elem())
+	}
+	return m.TypeMatch(vType, fn.In(i))
+}
+
+// 使用DefaultConvertMode，参考ConvertMode.Call
+func Call(fn reflect.Value, vs ...reflect.Value) []reflect.Value {
+	return DefaultConvertMode.Call(fn, vs...)
+}
+
+// 调用函数，按函数参数类型转换实参，并处理变长参数
+// 当实参数量与形参数量相同，且最后一个实参类型与变长切片类型一致时，展开传递
+func (m ConvertMode) Call(fn reflect.Value, vs ...reflect.Value) []reflect.Value {
+	ft := fn.Type()
+	args := make([]reflect.Value, len(vs))
+	if !ft.IsVariadic() {
+		for i := range vs {
+			args[i] = m.mustConvert(i, vs[i], ft.In(i))
+		}
+		return fn.Call(args)
+	}
+
+	last := ft.NumIn() - 1
+	if len(vs) == ft.NumIn() && (!vs[last].IsValid() || vs[last].Type() == NilType || vs[last].Type() == ft.In(last)) {
+		for i := range vs {
+			args[i] = m.mustConvert(i, vs[i], ft.In(i))
+		}
+		return fn.CallSlice(args)
+	}
+	for i := range vs {
+		if i < last {
+			args[i] = m.mustConvert(i, vs[i], ft.In(i))
+		} else {
+			args[i] = m.mustConvert(i, vs[i], ft.In(last).Elem())
+		}
+	}
+	return fn.Call(args)
+}
+
+// 转换参数，类型不匹配时panic（用于创建阶段时类型尚未确定的情况）
+func (m ConvertMode) mustConvert(i int, v reflect.Value, t reflect.Type) reflect.Value {
+	ret := m.ConvertValue(v, t)
+	if !ret.Type().AssignableTo(t) {
+		panic(fmt.Errorf("Type not match. in[%d] expect: %s get %s . ", i, t.String(), ret.Type().String()))
+	}
+	return ret
+}
+
 func CheckSupplyFunction(fn reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 0 || fn.NumOut() != 1 {
+	if !NumInMatch(fn, 0) || fn.NumOut() < 1 {
 		return errors.New("Type must be f func() TYPE . in[0] Function must be 0 In 1 Out. ")
 	}
 	return nil
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.CheckApplyFunction
 func CheckApplyFunction(fn reflect.Type, vType reflect.Type) error {
+	return DefaultConvertMode.CheckApplyFunction(fn, vType)
+}
+
+func (m ConvertMode) CheckApplyFunction(fn reflect.Type, vType reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 1 || fn.NumOut() != 1 {
+	if fn.NumOut() >= 1 && m.SpreadMatch(fn, vType) {
+		return nil
+	}
+	if !NumInMatch(fn, 1) || fn.NumOut() < 1 {
 		return errors.New("Type must be f func( TYPE) Type2 . in[0] Function must be 1 In 1 Out. ")
 	}
-	inType := fn.In(0)
-	if vType != NilType && inType != vType {
+	if !m.InMatch(fn, 0, vType) {
 		return errors.New("Type must be f func( TYPE) Type2 . in[0] not match. ")
 	}
 	return nil
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.CheckAcceptFunction
 func CheckAcceptFunction(fn reflect.Type, vType reflect.Type) error {
+	return DefaultConvertMode.CheckAcceptFunction(fn, vType)
+}
+
+func (m ConvertMode) CheckAcceptFunction(fn reflect.Type, vType reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 1 || fn.NumOut() != 0 {
+	if fn.NumOut() == 0 && m.SpreadMatch(fn, vType) {
+		return nil
+	}
+	if !NumInMatch(fn, 1) || fn.NumOut() != 0 {
 		return errors.New("Type must be f func( TYPE) . Function must be 1 In 0 Out. ")
 	}
-	inType := fn.In(0)
-	if vType != NilType && inType != vType {
+	if !m.InMatch(fn, 0, vType) {
 		return errors.New("Type must be f func( TYPE) . in[0] not match. ")
 	}
 	return nil
@@ -72,74 +241,88 @@ func CheckRunnableFunction(fn reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 0 || fn.NumOut() != 0 {
+	if !NumInMatch(fn, 0) || fn.NumOut() != 0 {
 		return errors.New("Type must be f func() . Function must be 0 In 0 Out. ")
 	}
 	return nil
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.CheckCombineFunction
 func CheckCombineFunction(fn reflect.Type, vType1, vType2 reflect.Type) error {
+	return DefaultConvertMode.CheckCombineFunction(fn, vType1, vType2)
+}
+
+func (m ConvertMode) CheckCombineFunction(fn reflect.Type, vType1, vType2 reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 2 || fn.NumOut() != 1 {
+	if !NumInMatch(fn, 2) || fn.NumOut() < 1 {
 		return errors.New("Type must be f func( TYPE,  Type2) Type3 . in[1] Function must be 2 In 1 Out. ")
 	}
-	inType1 := fn.In(0)
-	if vType1 != NilType && inType1 != vType1 {
+	if !m.InMatch(fn, 0, vType1) {
 		return errors.New("Type must be f func( TYPE,  Type2) Type3 . in[0] not match. ")
 	}
 
-	inType2 := fn.In(1)
-	if vType2 != NilType && inType2 != vType2 {
+	if !m.InMatch(fn, 1, vType2) {
 		return errors.New("Type must be f func( TYPE,  Type2) Type3 . in[1] not match. ")
 	}
 	return nil
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.CheckAcceptBothFunction
 func CheckAcceptBothFunction(fn reflect.Type, vType1, vType2 reflect.Type) error {
+	return DefaultConvertMode.CheckAcceptBothFunction(fn, vType1, vType2)
+}
+
+func (m ConvertMode) CheckAcceptBothFunction(fn reflect.Type, vType1, vType2 reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 2 || fn.NumOut() != 0 {
+	if !NumInMatch(fn, 2) || fn.NumOut() != 0 {
 		return errors.New("Type must be f func( TYPE,  Type2) . number not match. ")
 	}
-	inType1 := fn.In(0)
-	if vType1 != NilType && inType1 != vType1 {
+	if !m.InMatch(fn, 0, vType1) {
 		return errors.New("Type must be f func( TYPE,  Type2) . in[0] not match. ")
 	}
 
-	inType2 := fn.In(1)
-	if vType2 != NilType && inType2 != vType2 {
+	if !m.InMatch(fn, 1, vType2) {
 		return errors.New("Type must be f func( TYPE,  Type2) . in[1] not match. ")
 	}
 	return nil
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.CheckHandleFunction
 func CheckHandleFunction(fn reflect.Type, vType reflect.Type) error {
+	return DefaultConvertMode.CheckHandleFunction(fn, vType)
+}
+
+func (m ConvertMode) CheckHandleFunction(fn reflect.Type, vType reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 2 || fn.NumOut() != 1 {
+	if !NumInMatch(fn, 2) || fn.NumOut() < 1 {
 		return errors.New("Type must be f func(o TYPE1, err interface{}) TYPE2. number not match. ")
 	}
-	inType := fn.In(0)
-	if vType != NilType && inType != vType {
+	if !m.InMatch(fn, 0, vType) {
 		return errors.New("Type must be f func(o TYPE1, err interface{}) TYPE2. in[0] not match. ")
 	}
 
 	return nil
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.CheckWhenCompleteFunction
 func CheckWhenCompleteFunction(fn reflect.Type, vType reflect.Type) error {
+	return DefaultConvertMode.CheckWhenCompleteFunction(fn, vType)
+}
+
+func (m ConvertMode) CheckWhenCompleteFunction(fn reflect.Type, vType reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 2 || fn.NumOut() != 0 {
+	if !NumInMatch(fn, 2) || fn.NumOut() != 0 {
 		return errors.New("Type must be f func(o TYPE1, err interface{}). number not match. ")
 	}
-	inType := fn.In(0)
-	if vType != NilType && inType != vType {
+	if !m.InMatch(fn, 0, vType) {
 		return errors.New("Type must be f func(o TYPE1, err interface{}) . in[0] not match. ")
 	}
 
@@ -150,7 +333,7 @@ func CheckPanicFunction(fn reflect.Type) error {
 	if fn.Kind() != reflect.Func {
 		return errors.New("Param is not a function. ")
 	}
-	if fn.NumIn() != 1 || fn.NumOut() != 1 {
+	if !NumInMatch(fn, 1) || fn.NumOut() < 1 {
 		return errors.New("Type must be f func(o interface{}) TYPE. number not match. ")
 	}
 
@@ -165,77 +348,81 @@ func CheckPtr(v reflect.Type) error {
 }
 
 func RunSupply(fn reflect.Value) reflect.Value {
-	return fn.Call(nil)[0]
+	return OutValue(fn.Type(), Call(fn))
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.RunApply
 func RunApply(fn reflect.Value, v reflect.Value) reflect.Value {
-	if v == NilValue {
-		v = reflect.Zero(fn.Type().In(0))
-	}
-	return fn.Call([]reflect.Value{v})[0]
+	return DefaultConvertMode.RunApply(fn, v)
+}
+
+func (m ConvertMode) RunApply(fn reflect.Value, v reflect.Value) reflect.Value {
+	return OutValue(fn.Type(), m.callSpread(fn, v))
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.RunAccept
 func RunAccept(fn reflect.Value, v reflect.Value) {
-	if v == NilValue {
-		v = reflect.Zero(fn.Type().In(0))
-	}
-	fn.Call([]reflect.Value{v})
+	DefaultConvertMode.RunAccept(fn, v)
+}
+
+func (m ConvertMode) RunAccept(fn reflect.Value, v reflect.Value) {
+	m.callSpread(fn, v)
 }
 
 func RunRunnable(fn reflect.Value) {
-	fn.Call(nil)
+	Call(fn)
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.RunCombine
 func RunCombine(fn reflect.Value, v1, v2 reflect.Value) reflect.Value {
-	if v1 == NilValue {
-		v1 = reflect.Zero(fn.Type().In(0))
-	}
-	if v2 == NilValue {
-		v2 = reflect.Zero(fn.Type().In(1))
-	}
-	return fn.Call([]reflect.Value{v1, v2})[0]
+	return DefaultConvertMode.RunCombine(fn, v1, v2)
 }
 
+func (m ConvertMode) RunCombine(fn reflect.Value, v1, v2 reflect.Value) reflect.Value {
+	return OutValue(fn.Type(), m.Call(fn, v1, v2))
+}
+
+// 使用DefaultConvertMode，参考ConvertMode.RunAcceptBoth
 func RunAcceptBoth(fn reflect.Value, v1, v2 reflect.Value) {
-	if v1 == NilValue {
-		v1 = reflect.Zero(fn.Type().In(0))
-	}
-	if v2 == NilValue {
-		v2 = reflect.Zero(fn.Type().In(1))
-	}
-	fn.Call([]reflect.Value{v1, v2})
+	DefaultConvertMode.RunAcceptBoth(fn, v1, v2)
+}
+
+func (m ConvertMode) RunAcceptBoth(fn reflect.Value, v1, v2 reflect.Value) {
+	m.Call(fn, v1, v2)
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.RunCompose
 func RunCompose(fn reflect.Value, v reflect.Value) reflect.Value {
-	if v == NilValue {
-		v = reflect.Zero(fn.Type().In(0))
-	}
-	return fn.Call([]reflect.Value{v})[0]
+	return DefaultConvertMode.RunCompose(fn, v)
 }
 
+func (m ConvertMode) RunCompose(fn reflect.Value, v reflect.Value) reflect.Value {
+	return OutValue(fn.Type(), m.callSpread(fn, v))
+}
+
+// 使用DefaultConvertMode，参考ConvertMode.RunHandle
 func RunHandle(fn reflect.Value, v1, v2 reflect.Value) reflect.Value {
-	if v1 == NilValue {
-		v1 = reflect.Zero(fn.Type().In(0))
-	}
-	if v2 == NilValue {
-		v2 = reflect.Zero(fn.Type().In(1))
-	}
-	return fn.Call([]reflect.Value{v1, v2})[0]
+	return DefaultConvertMode.RunHandle(fn, v1, v2)
+}
+
+func (m ConvertMode) RunHandle(fn reflect.Value, v1, v2 reflect.Value) reflect.Value {
+	return OutValue(fn.Type(), m.Call(fn, v1, v2))
 }
 
+// 使用DefaultConvertMode，参考ConvertMode.RunWhenComplete
 func RunWhenComplete(fn reflect.Value, v1, v2 reflect.Value) {
-	if v1 == NilValue {
-		v1 = reflect.Zero(fn.Type().In(0))
-	}
-	if v2 == NilValue {
-		v2 = reflect.Zero(fn.Type().In(1))
-	}
-	fn.Call([]reflect.Value{v1, v2})
+	DefaultConvertMode.RunWhenComplete(fn, v1, v2)
 }
 
+func (m ConvertMode) RunWhenComplete(fn reflect.Value, v1, v2 reflect.Value) {
+	m.Call(fn, v1, v2)
+}
+
+// 使用DefaultConvertMode，参考ConvertMode.RunPanic
 func RunPanic(fn reflect.Value, v reflect.Value) reflect.Value {
-	if v == NilValue {
-		v = reflect.Zero(fn.Type().In(0))
-	}
-	return fn.Call([]reflect.Value{v})[0]
+	return DefaultConvertMode.RunPanic(fn, v)
+}
+
+func (m ConvertMode) RunPanic(fn reflect.Value, v reflect.Value) reflect.Value {
+	return OutValue(fn.Type(), m.Call(fn, v))
 }
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package functools
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// 多返回值函数的结果，可作为下一阶段函数的参数类型，也可作为Get的目标类型
+type Tuple []interface{}
+
+var TupleType = reflect.TypeOf(Tuple(nil))
+
+const tupleTag = `functools:"tuple"`
+
+// 创建元组类型，元组类型为结构体，字段依次为V0、V1...
+// 相同的参数类型返回相同的元组类型
+func TupleOf(types ...reflect.Type) reflect.Type {
+	fields := make([]reflect.StructField, len(types))
+	for i, t := range types {
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("V%d", i),
+			Type: t,
+			Tag:  tupleTag,
+		}
+	}
+	return reflect.StructOf(fields)
+}
+
+// 是否为TupleOf创建的元组类型
+func IsTuple(t reflect.Type) bool {
+	if t == nil || t.Kind() != reflect.Struct || t.NumField() < 2 {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag != tupleTag {
+			return false
+		}
+	}
+	return true
+}
+
+// 将元组展开为参数列表
+func SpreadTuple(v reflect.Value) []reflect.Value {
+	ret := make([]reflect.Value, v.NumField())
+	for i := range ret {
+		ret[i] = v.Field(i)
+	}
+	return ret
+}
+
+// 将元组转换为Tuple
+func ToTuple(v reflect.Value) Tuple {
+	ret := make(Tuple, v.NumField())
+	for i := range ret {
+		ret[i] = v.Field(i).Interface()
+	}
+	return ret
+}
+
+// 函数的返回值类型，多返回值时为元组类型
+func OutType(fn reflect.Type) reflect.Type {
+	if fn.NumOut() == 1 {
+		return fn.Out(0)
+	}
+	types := make([]reflect.Type, fn.NumOut())
+	for i := range types {
+		types[i] = fn.Out(i)
+	}
+	return TupleOf(types...)
+}
+
+// 函数的返回值，多返回值时组合为元组
+func OutValue(fn reflect.Type, outs []reflect.Value) reflect.Value {
+	if len(outs) == 1 {
+		return outs[0]
+	}
+	ret := reflect.New(OutType(fn)).Elem()
+	for i, v := range outs {
+		ret.Field(i).Set(v)
+	}
+	return ret
+}
+
+// 函数是否可以使用元组展开后的值调用（使用DefaultConvertMode）
+func SpreadMatch(fn reflect.Type, vType reflect.Type) bool {
+	return DefaultConvertMode.SpreadMatch(fn, vType)
+}
+
+// 函数是否可以使用元组展开后的值调用
+func (m ConvertMode) SpreadMatch(fn reflect.Type, vType reflect.Type) bool {
+	if !IsTuple(vType) || !NumInMatch(fn, vType.NumField()) {
+		return false
+	}
+	for i := 0; i < vType.NumField(); i++ {
+		if !m.InMatch(fn, i, vType.Field(i).Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// 调用单参数函数，如果值为元组且函数不能直接接收该元组，则展开元组调用
+func (m ConvertMode) callSpread(fn reflect.Value, v reflect.Value) []reflect.Value {
+	if v.IsValid() && IsTuple(v.Type()) {
+		ft := fn.Type()
+		if !NumInMatch(ft, 1) || !m.InMatch(ft, 0, v.Type()) {
+			return m.Call(fn, SpreadTuple(v)...)
+		}
+	}
+	return m.Call(fn, v)
+}
@@ -18,20 +18,91 @@ package completable
 
 import "time"
 
+// 阶段的生命周期状态
+type State int32
+
+const (
+	// 等待执行
+	Pending State = iota
+	// 正在执行
+	Running
+	// 正常完成
+	Succeeded
+	// 异常结束
+	Failed
+	// 被取消
+	Cancelled
+)
+
+func (s State) String() string {
+	switch s {
+	case Pending:
+		return "Pending"
+	case Running:
+		return "Running"
+	case Succeeded:
+		return "Succeeded"
+	case Failed:
+		return "Failed"
+	case Cancelled:
+		return "Cancelled"
+	}
+	return "Unknown"
+}
+
+// 是否为结束状态
+func (s State) IsTerminal() bool {
+	return s == Succeeded || s == Failed || s == Cancelled
+}
+
 type Future interface {
 	// 取消并打断stage链，退出任务
 	// 如果任务已完成返回false，成功取消返回true
 	Cancel() bool
 
+	// 指定取消原因，取消并打断stage链，退出任务
+	// Get返回及Handle获得的*CancellationError包含该原因，cause为nil时为context.Canceled
+	// 如果任务已完成返回false，成功取消返回true
+	CancelWithCause(cause error) bool
+
 	// 是否在完成前被取消
 	IsCancelled() bool
 
+	// 是否异常结束，异常或被取消都会返回true
+	IsCompletedExceptionally() bool
+
+	// 获得阶段当前的生命周期状态
+	State() State
+
+	Awaitable
+}
+
+// 仅用于等待结果
+type Awaitable interface {
 	// 是否任务完成
 	// 当任务正常完成，被取消，抛出异常都会返回true
 	IsDone() bool
 
+	// 返回阶段结束时关闭的channel，用法与context.Context.Done()相同
+	// 可被任意多个观察者等待，不会消耗阶段的结果
+	Done() <-chan struct{}
+
+	// 非阻塞获得阶段的结果，阶段未结束时返回false
+	Result() (ValueOrError, bool)
+
 	// 等待并获得任务执行结果
 	// Param： result 目标结果，必须为同类型的指针
 	// Param： timeout 等待超时时间，如果不传值则一直等待
 	Get(result interface{}, timeout ...time.Duration) error
+
+	// 立即返回阶段结果，不阻塞也不改变阶段状态
+	// 阶段已结束时与Get相同，否则将valueIfAbsent设置到result
+	// Param： valueIfAbsent 阶段未结束时返回的值
+	// Param： result 目标结果，必须为同类型的指针
+	GetNow(valueIfAbsent interface{}, result interface{}) error
+
+	// 立即返回阶段结果，不阻塞也不改变阶段状态
+	// 阶段已结束时返回true，错误与Get相同；阶段未结束时返回false，不修改result
+	// Param： result 目标结果，必须为同类型的指针
+	TryGet(result interface{}) (bool, error)
 }
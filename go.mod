@@ -1,5 +1,5 @@
 module github.com/xfali/completable
 
-go 1.12
+go 1.21
 
 require github.com/xfali/executor v0.0.2
@@ -0,0 +1,249 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"fmt"
+	"github.com/xfali/executor"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// 单个key的运行统计
+type KeyMetrics struct {
+	// key所在的串行通道
+	Lane int
+	// 等待执行的任务数
+	Queued int64
+	// 正在执行的任务数（同一个key最多为1）
+	Active int64
+}
+
+type keyStat struct {
+	queued int64
+	active int64
+}
+
+// 按key串行执行任务的协程池
+// key通过hash映射到固定数量的串行通道（每个通道为单工作协程、有界队列的WorkerPool），
+// 相同key的任务按提交顺序依次执行，不同key的任务可以并行执行（hash到同一通道的key之间也串行）
+// 通过WithKey获得绑定key的协程池视图后作为executor.Executor使用，
+// 后续阶段默认继承该视图，因此同一条阶段链上的任务也保持顺序
+// 注意：
+// 1、CallerRunsPolicy在提交任务的协程中执行，不再保证顺序
+// 2、任务中等待同一通道上的其他阶段会导致死锁
+// 3、补偿阻塞会破坏顺序，通道默认使用BlockingWait
+type KeyedExecutor struct {
+	lanes []*WorkerPool
+
+	lock  sync.Mutex
+	stats map[interface{}]*keyStat
+}
+
+// 创建按key串行执行任务的协程池
+// Param：lanes 串行通道数，小于1时为1
+// Param：queueSize 每个通道的等待队列长度，含义同NewWorkerPool
+// Param：opts 每个通道的协程池选项
+func NewKeyedExecutor(lanes, queueSize int, opts ...PoolOpt) *KeyedExecutor {
+	if lanes < 1 {
+		lanes = 1
+	}
+	ret := &KeyedExecutor{
+		lanes: make([]*WorkerPool, lanes),
+		stats: map[interface{}]*keyStat{},
+	}
+	opts = append([]PoolOpt{PoolBlockingMode(BlockingWait)}, opts...)
+	for i := range ret.lanes {
+		ret.lanes[i] = NewWorkerPool(1, queueSize, opts...)
+	}
+	return ret
+}
+
+// 获得绑定key的协程池视图，视图实现executor.Executor及PolicyExecutor
+// 视图的Stop不做任何操作，需通过KeyedExecutor的Stop停止
+// key需可以作为map的key使用
+func (k *KeyedExecutor) WithKey(key interface{}) executor.Executor {
+	return &keyedView{
+		owner: k,
+		key:   key,
+		lane:  k.laneOf(key),
+	}
+}
+
+// 在key对应的通道上执行一个任务
+func (k *KeyedExecutor) RunKey(key interface{}, task executor.Task) error {
+	return k.WithKey(key).Run(task)
+}
+
+// 获得key所在的串行通道
+func (k *KeyedExecutor) laneOf(key interface{}) int {
+	h := fnv.New64a()
+	switch v := key.(type) {
+	case string:
+		h.Write([]byte(v))
+	default:
+		fmt.Fprintf(h, "%T:%v", key, key)
+	}
+	return int(h.Sum64() % uint64(len(k.lanes)))
+}
+
+// 停止协程池：拒绝新的任务，等待已接受的任务全部执行结束
+func (k *KeyedExecutor) Stop() {
+	k.each(func(p *WorkerPool) { p.Stop() })
+}
+
+// 立即停止协程池：拒绝新的任务，丢弃等待中的任务并等待正在执行的任务结束
+func (k *KeyedExecutor) StopNow() {
+	k.each(func(p *WorkerPool) { p.StopNow() })
+}
+
+func (k *KeyedExecutor) each(f func(p *WorkerPool)) {
+	wg := sync.WaitGroup{}
+	wg.Add(len(k.lanes))
+	for _, p := range k.lanes {
+		go func(p *WorkerPool) {
+			defer wg.Done()
+			f(p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// 获得每个串行通道的运行统计
+func (k *KeyedExecutor) Metrics() []PoolMetrics {
+	ret := make([]PoolMetrics, len(k.lanes))
+	for i, p := range k.lanes {
+		ret[i] = p.Metrics()
+	}
+	return ret
+}
+
+// 获得key的运行统计，没有等待或正在执行的任务时Queued及Active为0
+func (k *KeyedExecutor) KeyMetrics(key interface{}) KeyMetrics {
+	ret := KeyMetrics{Lane: k.laneOf(key)}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if s, ok := k.stats[key]; ok {
+		ret.Queued = s.queued
+		ret.Active = s.active
+	}
+	return ret
+}
+
+// 获得所有有等待或正在执行任务的key的运行统计
+func (k *KeyedExecutor) AllKeyMetrics() map[interface{}]KeyMetrics {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	ret := make(map[interface{}]KeyMetrics, len(k.stats))
+	for key, s := range k.stats {
+		ret[key] = KeyMetrics{
+			Lane:   k.laneOf(key),
+			Queued: s.queued,
+			Active: s.active,
+		}
+	}
+	return ret
+}
+
+// 修改key的统计，均为0时删除
+func (k *KeyedExecutor) update(key interface{}, queued, active int64) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	s, ok := k.stats[key]
+	if !ok {
+		s = &keyStat{}
+		k.stats[key] = s
+	}
+	s.queued += queued
+	s.active += active
+	if s.queued <= 0 && s.active <= 0 {
+		delete(k.stats, key)
+	}
+}
+
+type keyedView struct {
+	owner *KeyedExecutor
+	key   interface{}
+	lane  int
+}
+
+func (v *keyedView) Run(task executor.Task) error {
+	return v.RunPolicy(context.Background(), task, RejectionPolicy{})
+}
+
+func (v *keyedView) RunContext(ctx context.Context, task executor.Task) error {
+	return v.RunPolicy(ctx, task, RejectionPolicy{})
+}
+
+func (v *keyedView) RunPolicy(ctx context.Context, task executor.Task, policy RejectionPolicy) error {
+	k := v.owner
+	// 0：等待执行，1：已开始执行或已丢弃
+	var state int32
+	k.update(v.key, 1, 0)
+	dropped := func() {
+		if atomic.CompareAndSwapInt32(&state, 0, 1) {
+			k.update(v.key, -1, 0)
+		}
+	}
+	ctx = withDropHook(ctx, dropped)
+	err := k.lanes[v.lane].RunPolicy(ctx, func() {
+		if !atomic.CompareAndSwapInt32(&state, 0, 1) {
+			return
+		}
+		k.update(v.key, -1, 1)
+		defer k.update(v.key, 0, -1)
+		task()
+	}, policy)
+	if err != nil {
+		dropped()
+	}
+	return err
+}
+
+func (v *keyedView) Stop() {}
+
+// 使用key对应的通道执行f，参考SupplyAsync
+func SupplyAsyncKeyed(exec *KeyedExecutor, key interface{}, f interface{}, opts ...executor.Executor) CompletionStage {
+	return SupplyAsync(f, keyedOptions(exec, key, opts)...)
+}
+
+// 使用key对应的通道执行f，参考RunAsync
+func RunAsyncKeyed(exec *KeyedExecutor, key interface{}, f func(), opts ...executor.Executor) CompletionStage {
+	return RunAsync(f, keyedOptions(exec, key, opts)...)
+}
+
+// 使用key对应的通道执行applyFunc，参考CompletionStage.ThenApplyAsync
+func ThenApplyAsyncKeyed(stage CompletionStage, exec *KeyedExecutor, key interface{}, applyFunc interface{}, opts ...executor.Executor) CompletionStage {
+	return stage.ThenApplyAsync(applyFunc, keyedOptions(exec, key, opts)...)
+}
+
+// 使用key对应的通道执行acceptFunc，参考CompletionStage.ThenAcceptAsync
+func ThenAcceptAsyncKeyed(stage CompletionStage, exec *KeyedExecutor, key interface{}, acceptFunc interface{}, opts ...executor.Executor) CompletionStage {
+	return stage.ThenAcceptAsync(acceptFunc, keyedOptions(exec, key, opts)...)
+}
+
+// 使用key对应的通道执行runnable，参考CompletionStage.ThenRunAsync
+func ThenRunAsyncKeyed(stage CompletionStage, exec *KeyedExecutor, key interface{}, runnable interface{}, opts ...executor.Executor) CompletionStage {
+	return stage.ThenRunAsync(runnable, keyedOptions(exec, key, opts)...)
+}
+
+// key对应的视图在前，opts中通过WithExecutor指定的协程池优先
+func keyedOptions(exec *KeyedExecutor, key interface{}, opts []executor.Executor) []executor.Executor {
+	return append([]executor.Executor{exec.WithKey(key)}, opts...)
+}
@@ -107,7 +107,7 @@ func (cf *lazyCompletableFuture) ThenRun(runnable interface{}) completable.Compl
 
 // 当阶段正常完成时执行参数函数：不关心上一步结果
 // Param：参数函数: f func()
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) ThenRunAsync(runnable interface{}, executor ...executor.Executor) completable.CompletionStage {
 	ret := &lazyCompletableFuture{
@@ -139,7 +139,7 @@ func (cf *lazyCompletableFuture) ThenCombine(other completable.CompletionStage,
 // 当阶段正常完成时执行参数函数：结合两个CompletionStage的结果，转化后返回
 // Param：other，当该CompletionStage也返回后进行结合转化
 // Param：参数函数，combineFunc func(TYPE1, TYPE2) TYPE3参数为两个CompletionStage的结果，返回转化结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) ThenCombineAsync(other completable.CompletionStage, combineFunc interface{}, executor ...executor.Executor) completable.CompletionStage {
 	ret := &lazyCompletableFuture{
@@ -172,7 +172,7 @@ func (cf *lazyCompletableFuture) ThenAcceptBoth(other completable.CompletionStag
 // 当阶段正常完成时执行参数函数：结合两个CompletionStage的结果，进行消耗
 // Param：other，当该CompletionStage也返回后进行消耗
 // Param：参数函数，acceptFunc func(TYPE1, TYPE2) 参数为两个CompletionStage的结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) ThenAcceptBothAsync(
 	other completable.CompletionStage, acceptFunc interface{}, executor ...executor.Executor) completable.CompletionStage {
@@ -206,7 +206,7 @@ func (cf *lazyCompletableFuture) RunAfterBoth(other completable.CompletionStage,
 // 当阶段正常完成时执行参数函数：两个CompletionStage都完成后执行
 // Param：other，当该CompletionStage也完成后执行参数函数
 // Param：参数函数 runnable func()
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) RunAfterBothAsync(other completable.CompletionStage, runnable interface{}, executor ...executor.Executor) completable.CompletionStage {
 	ret := &lazyCompletableFuture{
@@ -239,7 +239,7 @@ func (cf *lazyCompletableFuture) ApplyToEither(other completable.CompletionStage
 // 当阶段正常完成时执行参数函数：两个CompletionStage使用先完成的结果进行转化
 // Param：other，与该CompletionStage比较，用先完成的结果进行转化，注意两个CompletionStage的返回结果类型必须相同
 // Param：参数函数 f func(o Type1) Type2参数为先完成的CompletionStage的结果，返回转化结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) ApplyToEitherAsync(other completable.CompletionStage, applyFunc interface{}, executor ...executor.Executor) completable.CompletionStage {
 	ret := &lazyCompletableFuture{
@@ -272,7 +272,7 @@ func (cf *lazyCompletableFuture) AcceptEither(other completable.CompletionStage,
 // 当阶段正常完成时执行参数函数：两个CompletionStage使用先完成的结果进行消耗
 // Param：other，与该CompletionStage比较，用先完成的结果进行消耗，注意两个CompletionStage的返回结果类型必须相同
 // Param：参数函数 f func(o Type)参数为先完成的CompletionStage的结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) AcceptEitherAsync(other completable.CompletionStage, acceptFunc interface{}, executor ...executor.Executor) completable.CompletionStage {
 	ret := &lazyCompletableFuture{
@@ -305,7 +305,7 @@ func (cf *lazyCompletableFuture) RunAfterEither(other completable.CompletionStag
 // 当阶段正常完成时执行参数函数：两个CompletionStage任意一个完成则执行操作
 // Param：other，与该CompletionStage比较，任意一个完成则执行操作，注意两个CompletionStage的返回结果类型必须相同
 // Param：参数函数
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) RunAfterEitherAsync(other completable.CompletionStage, runnable interface{}, executor ...executor.Executor) completable.CompletionStage {
 	ret := &lazyCompletableFuture{
@@ -335,7 +335,7 @@ func (cf *lazyCompletableFuture) ThenCompose(f interface{}) completable.Completi
 
 // 当阶段正常完成时执行参数函数：使用上一阶段结果转化为新的CompletionStage
 // Param：参数函数，f func(o TYPE) CompletionStage 参数：上一阶段结果，返回新的CompletionStage
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) ThenComposeAsync(f interface{}, executor ...executor.Executor) completable.CompletionStage {
 	ret := &lazyCompletableFuture{
@@ -378,7 +378,7 @@ func (cf *lazyCompletableFuture) WhenComplete(f interface{}) completable.Complet
 
 // 阶段执行时获得结果或者panic,注意会继续传递panic
 // Param：参数函数，f func(result Type, panic interface{}) 参数result：结果，参数panic：异常
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) WhenCompleteAsync(f interface{}, executor ...executor.Executor) completable.CompletionStage {
 	ret := &lazyCompletableFuture{
@@ -407,7 +407,7 @@ func (cf *lazyCompletableFuture) Handle(f interface{}) completable.CompletionSta
 
 // 阶段执行时获得结果或者panic,并转化结果
 // Param：参数函数，f func(result TYPE1, panic interface{}) TYPE2 参数result：结果，参数panic：异常，返回：转化的结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *lazyCompletableFuture) HandleAsync(f interface{}, executor ...executor.Executor) completable.CompletionStage {
 	ret := &lazyCompletableFuture{
@@ -443,13 +443,36 @@ func (cf *lazyCompletableFuture) CompleteExceptionally(v interface{}) error {
 	return nil
 }
 
+// 强制设置阶段的值，阶段链未执行时返回错误
+func (cf *lazyCompletableFuture) ObtrudeValue(v interface{}) error {
+	o := cf.getOrigin()
+	if o == nil {
+		return errors.New("Lazy CompletableFuture not started. ")
+	}
+	return o.ObtrudeValue(v)
+}
+
+// 强制设置阶段的panic，阶段链未执行时返回错误
+func (cf *lazyCompletableFuture) ObtrudeException(cause interface{}) error {
+	o := cf.getOrigin()
+	if o == nil {
+		return errors.New("Lazy CompletableFuture not started. ")
+	}
+	return o.ObtrudeException(cause)
+}
+
 // 取消并打断stage链，退出任务
 // 如果任务已完成返回false，成功取消返回true
 func (cf *lazyCompletableFuture) Cancel() bool {
+	return cf.CancelWithCause(nil)
+}
+
+// 指定取消原因，取消并打断stage链，退出任务
+func (cf *lazyCompletableFuture) CancelWithCause(cause error) bool {
 	for h := cf.header; h != nil; h = h.next {
 		o := h.getOrigin()
 		if o != nil {
-			o.Cancel()
+			o.CancelWithCause(cause)
 		}
 	}
 	return true
@@ -457,22 +480,45 @@ func (cf *lazyCompletableFuture) Cancel() bool {
 
 // 是否在完成前被取消
 func (cf *lazyCompletableFuture) IsCancelled() bool {
+	return cf.State() == completable.Cancelled
+}
+
+// 是否任务完成
+// 当任务正常完成，被取消，抛出异常都会返回true
+func (cf *lazyCompletableFuture) IsDone() bool {
+	return cf.State().IsTerminal()
+}
+
+// 是否异常结束，异常或被取消都会返回true
+func (cf *lazyCompletableFuture) IsCompletedExceptionally() bool {
+	s := cf.State()
+	return s == completable.Failed || s == completable.Cancelled
+}
+
+// 获得阶段当前的生命周期状态
+// 阶段链未执行时为Pending
+func (cf *lazyCompletableFuture) State() completable.State {
 	o := cf.getOrigin()
 	if o == nil {
-		return false
+		return completable.Pending
 	} else {
-		return o.IsCancelled()
+		return o.State()
 	}
 }
 
-// 是否任务完成
-// 当任务正常完成，被取消，抛出异常都会返回true
-func (cf *lazyCompletableFuture) IsDone() bool {
+// 返回阶段结束时关闭的channel
+// 与Get相同，调用时会执行阶段链
+func (cf *lazyCompletableFuture) Done() <-chan struct{} {
+	return cf.join().Done()
+}
+
+// 非阻塞获得阶段的结果，阶段链未执行或阶段未结束时返回false
+func (cf *lazyCompletableFuture) Result() (completable.ValueOrError, bool) {
 	o := cf.getOrigin()
 	if o == nil {
-		return false
+		return nil, false
 	} else {
-		return o.IsDone()
+		return o.Result()
 	}
 }
 
@@ -525,6 +571,28 @@ func (cf *lazyCompletableFuture) Get(result interface{}, timeout ...time.Duratio
 	return o.Get(result, timeout...)
 }
 
+// 立即返回阶段结果，不阻塞也不执行阶段链
+// 阶段已结束时与Get相同，否则将valueIfAbsent设置到result
+func (cf *lazyCompletableFuture) GetNow(valueIfAbsent interface{}, result interface{}) error {
+	o := cf.getOrigin()
+	if o == nil {
+		// 使用未完成的阶段处理valueIfAbsent，保持与默认实现相同的转换规则
+		pending, _ := completable.NewCompletableFuture(nil)
+		return pending.GetNow(valueIfAbsent, result)
+	}
+	return o.GetNow(valueIfAbsent, result)
+}
+
+// 立即返回阶段结果，不阻塞也不执行阶段链
+// 阶段已结束时返回true，错误与Get相同；阶段未结束时返回false
+func (cf *lazyCompletableFuture) TryGet(result interface{}) (bool, error) {
+	o := cf.getOrigin()
+	if o == nil {
+		return false, nil
+	}
+	return o.TryGet(result)
+}
+
 func joinOriginCompletableStage(o completable.CompletionStage) completable.CompletionStage {
 	if o == nil {
 		return nil
@@ -551,10 +619,10 @@ func getOriginCompletableStage(o completable.CompletionStage) completable.Comple
 	}
 }
 
-func CompletedFuture(value interface{}) (retCf completable.CompletionStage) {
+func CompletedFuture(value interface{}, opts ...completable.Option) (retCf completable.CompletionStage) {
 	ret := &lazyCompletableFuture{
 		fn: func(o completable.CompletionStage) completable.CompletionStage {
-			return completable.CompletedFuture(value)
+			return completable.CompletedFuture(value, opts...)
 		},
 	}
 	ret.header = ret
@@ -582,6 +650,28 @@ func RunAsync(f func(), executor ...executor.Executor) (retCf completable.Comple
 }
 
 func AllOf(cfs ...completable.CompletionStage) (retCf completable.CompletionStage) {
+	return AllOfWithOptions(cfs)
+}
+
+func AllOfWithOptions(cfs []completable.CompletionStage, opts ...completable.Option) (retCf completable.CompletionStage) {
+	ret := &lazyCompletableFuture{
+		fn: func(origin completable.CompletionStage) completable.CompletionStage {
+			origins := make([]completable.CompletionStage, len(cfs))
+			for i := range cfs {
+				if v, ok := cfs[i].(*lazyCompletableFuture); ok {
+					origins[i] = v.join()
+				} else {
+					origins[i] = cfs[i]
+				}
+			}
+			return completable.AllOfWithOptions(origins, opts...)
+		},
+	}
+	ret.header = ret
+	return ret
+}
+
+func AnyOfWithOptions(cfs []completable.CompletionStage, opts ...completable.Option) (retCf completable.CompletionStage) {
 	ret := &lazyCompletableFuture{
 		fn: func(origin completable.CompletionStage) completable.CompletionStage {
 			origins := make([]completable.CompletionStage, len(cfs))
@@ -592,7 +682,7 @@ func AllOf(cfs ...completable.CompletionStage) (retCf completable.CompletionStag
 					origins[i] = cfs[i]
 				}
 			}
-			return completable.AllOf(origins...)
+			return completable.AnyOfWithOptions(origins, opts...)
 		},
 	}
 	ret.header = ret
@@ -0,0 +1,47 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lazycompletable
+
+import (
+	"github.com/xfali/completable"
+	"github.com/xfali/executor"
+)
+
+type lazyFactory struct{}
+
+// lazycompletable实现的工厂，阶段在获取结果时才执行
+var Factory completable.Factory = lazyFactory{}
+
+func (f lazyFactory) CompletedFuture(value interface{}) completable.CompletionStage {
+	return CompletedFuture(value)
+}
+
+func (f lazyFactory) SupplyAsync(fn interface{}, executor ...executor.Executor) completable.CompletionStage {
+	return SupplyAsync(fn, executor...)
+}
+
+func (f lazyFactory) RunAsync(fn func(), executor ...executor.Executor) completable.CompletionStage {
+	return RunAsync(fn, executor...)
+}
+
+func (f lazyFactory) AllOf(cfs ...completable.CompletionStage) completable.CompletionStage {
+	return AllOf(cfs...)
+}
+
+func (f lazyFactory) AnyOf(cfs ...completable.CompletionStage) completable.CompletionStage {
+	return AnyOf(cfs...)
+}
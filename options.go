@@ -0,0 +1,204 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/executor"
+	"time"
+)
+
+// 阶段的创建选项，用于SupplyAsync、RunAsync及阶段的*Async方法
+// 选项与协程池使用同一个可变参数传入，例如：
+//
+//	cf.ThenApplyAsync(f, pool, completable.WithName("load"), completable.WithTimeout(time.Second))
+//
+// Option实现了executor.Executor，因此原有只传协程池的调用方式不变，
+// 而其他类型的参数在编译时即被拒绝
+// Option只能作为阶段参数，作为协程池传给SetDefaultExecutor、EngineExecutor、
+// Engine.SetExecutor、WithExecutor及DelayedExecutor时panic
+// 根阶段通过CompletedFuture、NewCompletableFuture、NewPromise、AllOfWithOptions及AnyOfWithOptions指定选项
+type Option func(o *stageOptions)
+
+type stageOptions struct {
+	executor    executor.Executor
+	name        string
+	timeout     time.Duration
+	ctx         context.Context
+	panicPolicy *PanicPolicy
+	rejection   RejectionPolicy
+	priority    *int
+}
+
+// 指定执行阶段的协程池，与直接传入协程池相同
+func WithExecutor(exec executor.Executor) Option {
+	checkExecutor(exec)
+	return func(o *stageOptions) {
+		o.executor = exec
+	}
+}
+
+// 指定阶段的名称，可通过StageName获得，用于日志及钩子
+func WithName(name string) Option {
+	return func(o *stageOptions) {
+		o.name = name
+	}
+}
+
+// 指定阶段的超时时间（从创建开始计算，使用引擎的时钟）
+// 超时未结束的阶段被取消，取消原因为context.DeadlineExceeded
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *stageOptions) {
+		o.timeout = timeout
+	}
+}
+
+// 指定阶段关联的context，ctx结束时取消阶段，取消原因为context.Cause(ctx)
+// ctx为任务的context（ThenCompose参数函数的ctx参数或WorkStealingPool.Fork任务的ctx）时，
+// 在该任务中等待阶段（Get、同步的Then*方法等）按执行任务的协程池的BlockingMode补偿
+func WithContext(ctx context.Context) Option {
+	return func(o *stageOptions) {
+		o.ctx = ctx
+	}
+}
+
+// 指定阶段的panic策略，覆盖引擎的设置
+func WithPanicPolicy(policy PanicPolicy) Option {
+	return func(o *stageOptions) {
+		o.panicPolicy = &policy
+	}
+}
+
+// 指定阶段任务被协程池拒绝时的处理策略，覆盖协程池的设置
+func WithRejectionPolicy(policy RejectionPolicy) Option {
+	return func(o *stageOptions) {
+		o.rejection = policy
+	}
+}
+
+// 指定阶段任务的优先级，数值越大越优先，后续阶段默认继承
+// 支持优先级的协程池（如PriorityExecutor）通过TaskPriority获得
+func WithPriority(priority int) Option {
+	return func(o *stageOptions) {
+		o.priority = &priority
+	}
+}
+
+var (
+	errOptionNotExecutor = errors.New("Option without executor cannot run task. ")
+	errOptionAsExecutor  = errors.New("Option cannot be used as an executor. ")
+)
+
+// 检查作为协程池使用的参数，Option不能作为协程池
+func checkExecutor(exec executor.Executor) {
+	if _, ok := exec.(Option); ok {
+		panic(errOptionAsExecutor)
+	}
+}
+
+// 选项作为协程池使用时，使用WithExecutor指定的协程池执行
+func (opt Option) Run(task executor.Task) error {
+	o := stageOptions{}
+	opt(&o)
+	if o.executor == nil {
+		return errOptionNotExecutor
+	}
+	return o.executor.Run(task)
+}
+
+func (opt Option) Stop() {}
+
+// 解析可变参数中的选项及协程池，后面的参数覆盖前面的参数
+func parseOptions(opts ...executor.Executor) stageOptions {
+	ret := stageOptions{}
+	for _, v := range opts {
+		if v == nil {
+			continue
+		}
+		if opt, ok := v.(Option); ok {
+			if opt != nil {
+				opt(&ret)
+			}
+		} else {
+			ret.executor = v
+		}
+	}
+	return ret
+}
+
+// 获得阶段的名称，未指定名称或非默认实现时返回空字符串
+func StageName(stage CompletionStage) string {
+	if cf, ok := stage.(*defaultCompletableFuture); ok {
+		return cf.name
+	}
+	return ""
+}
+
+// 将选项应用到阶段，返回执行阶段任务的协程池
+func (cf *defaultCompletableFuture) applyOptions(opts ...executor.Executor) executor.Executor {
+	o := parseOptions(opts...)
+	cf.name = o.name
+	if o.panicPolicy != nil {
+		cf.panicPolicy = o.panicPolicy
+	}
+	cf.rejection = o.rejection
+	if o.priority != nil {
+		cf.priority = o.priority
+	}
+	if o.ctx != nil {
+		ctx := o.ctx
+		// 以任务的context创建的阶段在该任务中等待时由执行任务的协程池补偿
+		if owner, ok := ctx.Value(ownerKey{}).(*taskOwner); ok && cf.ctx != nil {
+			cf.ctx = context.WithValue(cf.ctx, ownerKey{}, owner)
+		}
+		stop := context.AfterFunc(ctx, func() {
+			cf.CancelWithCause(context.Cause(ctx))
+		})
+		cf.handler().onComplete(func() {
+			stop()
+		})
+	}
+	if o.timeout > 0 {
+		stop := cf.engine.Clock().AfterFunc(o.timeout, func() {
+			cf.CancelWithCause(context.DeadlineExceeded)
+		})
+		cf.handler().onComplete(func() {
+			stop()
+		})
+	}
+	exec := o.executor
+	if exec == nil && cf.engine.InheritExecutor() {
+		exec = cf.exec
+	}
+	if exec != nil {
+		cf.exec = inheritedExecutor(exec)
+	}
+	return cf.chooseExecutor(exec)
+}
+
+// 将选项应用到根阶段，根阶段没有任务，指定的协程池只由后续阶段继承
+func (cf *defaultCompletableFuture) applyRootOptions(opts []Option) {
+	if len(opts) == 0 {
+		return
+	}
+	execs := make([]executor.Executor, 0, len(opts))
+	for _, opt := range opts {
+		execs = append(execs, opt)
+	}
+	cf.applyOptions(execs...)
+}
@@ -0,0 +1,428 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xfali/executor"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrPoolStopped = errors.New("WorkerPool is stopped. ")
+	ErrPoolFull    = errors.New("WorkerPool queue is full. ")
+)
+
+// 补偿阻塞时临时工作协程数的默认上限
+const DefaultMaxSpawn = 256
+
+// 协程池的运行统计
+type PoolMetrics struct {
+	// 工作协程数
+	Workers int
+	// 正在执行的任务数
+	Active int64
+	// 等待执行的任务数
+	Queued int64
+	// 执行结束的任务数（包括panic的任务）
+	Completed int64
+	// 被拒绝的任务数（队列已满、已停止或StopNow丢弃）
+	Rejected int64
+	// 执行时panic的任务数
+	Panicked int64
+	// 阻塞等待其他阶段的工作协程数
+	Blocked int64
+	// 补偿阻塞的临时工作协程数
+	Spawned int64
+}
+
+type poolTask struct {
+	ctx  context.Context
+	task executor.Task
+	// 执行任务的工作窃取协程池的工作协程
+	worker *stealWorker
+}
+
+// WorkerPool、PriorityExecutor及WorkStealingPool共用的部分：任务执行、临时工作协程、拒绝及运行统计
+type poolBase struct {
+	// 嵌入poolBase的协程池
+	pool     managedPool
+	size     int
+	blocking BlockingMode
+	wg       sync.WaitGroup
+	// 任务panic时调用，为nil时不报告
+	panicHandler func(v interface{}, stack []byte)
+	// 临时工作协程数的上限
+	maxSpawn int
+
+	active    int64
+	completed int64
+	rejected  int64
+	panicked  int64
+	blocked   int64
+	spawned   int64
+}
+
+// 以err拒绝任务
+func (p *poolBase) reject(err error) error {
+	atomic.AddInt64(&p.rejected, 1)
+	return &RejectedError{Cause: err}
+}
+
+// 丢弃已接受的任务，阶段任务以ErrRejected为原因被取消
+func (p *poolBase) discard(ctx context.Context) {
+	atomic.AddInt64(&p.rejected, 1)
+	DiscardTask(ctx)
+}
+
+// 停止时丢弃已接受的任务，阶段任务以ErrPoolStopped异常结束
+func (p *poolBase) drop(ctx context.Context) {
+	atomic.AddInt64(&p.rejected, 1)
+	RejectTask(ctx, &RejectedError{Cause: ErrPoolStopped})
+}
+
+func (p *poolBase) execute(t poolTask) {
+	if t.ctx.Err() != nil {
+		dropTask(t.ctx)
+		return
+	}
+	if owner := stageOwner(t.ctx); owner != nil {
+		owner.begin(p.pool, t.worker)
+		defer owner.end()
+	}
+	atomic.AddInt64(&p.active, 1)
+	defer func() {
+		if o := recover(); o != nil {
+			atomic.AddInt64(&p.panicked, 1)
+			if p.panicHandler != nil {
+				p.panicHandler(o, debug.Stack())
+			}
+		}
+		atomic.AddInt64(&p.active, -1)
+		atomic.AddInt64(&p.completed, 1)
+	}()
+	t.task()
+}
+
+// 默认的panic处理：通过默认引擎的panic日志输出panic值及堆栈
+func logPoolPanic(v interface{}, stack []byte) {
+	defaultEngine.logPanic([]byte(fmt.Sprintf("pool task panic: %v\n%s", v, stack)))
+}
+
+func (p *poolBase) spawn(f func()) bool {
+	if atomic.AddInt64(&p.spawned, 1) > int64(p.maxSpawn) {
+		atomic.AddInt64(&p.spawned, -1)
+		return false
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer atomic.AddInt64(&p.spawned, -1)
+		f()
+	}()
+	return true
+}
+
+func (p *poolBase) setMaxSpawn(n int) {
+	if n < 0 {
+		n = 0
+	}
+	p.maxSpawn = n
+}
+
+func (p *poolBase) blockingMode() BlockingMode {
+	return p.blocking
+}
+
+func (p *poolBase) addBlocked(delta int64) int64 {
+	return atomic.AddInt64(&p.blocked, delta)
+}
+
+func (p *poolBase) workerCount() int {
+	return p.size
+}
+
+func (p *poolBase) metrics(queued int64) PoolMetrics {
+	return PoolMetrics{
+		Workers:   p.size,
+		Active:    atomic.LoadInt64(&p.active),
+		Queued:    queued,
+		Completed: atomic.LoadInt64(&p.completed),
+		Rejected:  atomic.LoadInt64(&p.rejected),
+		Panicked:  atomic.LoadInt64(&p.panicked),
+		Blocked:   atomic.LoadInt64(&p.blocked),
+		Spawned:   atomic.LoadInt64(&p.spawned),
+	}
+}
+
+// 固定工作协程数及队列长度的协程池，实现executor.Executor及PolicyExecutor
+// 队列已满时按拒绝策略处理（默认AbortPolicy，返回包装ErrPoolFull的*RejectedError），
+// 停止后返回包装ErrPoolStopped的*RejectedError
+// 工作协程阻塞等待其他阶段时按BlockingMode补偿（默认BlockingSpawn）
+type WorkerPool struct {
+	poolBase
+	queue  chan poolTask
+	policy RejectionPolicy
+
+	lock     sync.RWMutex
+	stopped  bool
+	stopOnce sync.Once
+	// 开始停止时关闭，唤醒等待入队的任务
+	quit chan struct{}
+	// 不再有任务入队后关闭，工作协程处理剩余任务后退出
+	sealed chan struct{}
+	// StopNow时丢弃队列中的任务
+	discarding int32
+}
+
+type PoolOpt func(p *WorkerPool)
+
+// 设置协程池的拒绝策略，阶段通过WithRejectionPolicy指定的策略优先
+func PoolRejectionPolicy(policy RejectionPolicy) PoolOpt {
+	return func(p *WorkerPool) {
+		p.policy = policy
+	}
+}
+
+// 设置工作协程阻塞等待其他阶段时的补偿方式
+func PoolBlockingMode(mode BlockingMode) PoolOpt {
+	return func(p *WorkerPool) {
+		p.blocking = mode
+	}
+}
+
+// 设置补偿阻塞时临时工作协程数的上限，默认为DefaultMaxSpawn，小于0时为0
+// 达到上限后在等待的工作协程中执行队列中的任务（同BlockingRunInline）
+func PoolMaxSpawn(n int) PoolOpt {
+	return func(p *WorkerPool) {
+		p.setMaxSpawn(n)
+	}
+}
+
+// 设置任务panic时的处理函数，默认通过默认引擎的panic日志（SetLogPanicStacks）输出，为nil时不报告
+// 阶段任务的panic由阶段记录，不会到达协程池
+func PoolPanicHandler(f func(v interface{}, stack []byte)) PoolOpt {
+	return func(p *WorkerPool) {
+		p.panicHandler = f
+	}
+}
+
+// 创建协程池
+// Param：workers 工作协程数，小于1时为1
+// Param：queueSize 等待队列长度，NewWorkerPool、NewPriorityExecutor及NewKeyedExecutor含义相同：
+// 工作协程都在执行任务时最多可以等待的任务数，小于等于0时没有等待队列，
+// 任务只交给空闲的工作协程，没有空闲工作协程时按拒绝策略处理
+func NewWorkerPool(workers, queueSize int, opts ...PoolOpt) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	ret := &WorkerPool{
+		queue:  make(chan poolTask, queueSize),
+		policy: AbortPolicy,
+		quit:   make(chan struct{}),
+		sealed: make(chan struct{}),
+	}
+	ret.poolBase = poolBase{pool: ret, size: workers, panicHandler: logPoolPanic, maxSpawn: DefaultMaxSpawn}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	ret.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go ret.loop()
+	}
+	return ret
+}
+
+func (p *WorkerPool) Run(task executor.Task) error {
+	return p.RunPolicy(context.Background(), task, RejectionPolicy{})
+}
+
+// 执行一个任务，ctx被取消时丢弃尚未执行的任务
+func (p *WorkerPool) RunContext(ctx context.Context, task executor.Task) error {
+	return p.RunPolicy(ctx, task, RejectionPolicy{})
+}
+
+// 执行一个任务，队列已满时按policy处理，policy为零值时使用协程池的策略
+func (p *WorkerPool) RunPolicy(ctx context.Context, task executor.Task, policy RejectionPolicy) error {
+	if policy.mode == rejectDefault {
+		policy = p.policy
+	}
+	t := poolTask{ctx: ctx, task: task}
+
+	p.lock.RLock()
+	if p.stopped {
+		p.lock.RUnlock()
+		return p.reject(ErrPoolStopped)
+	}
+	select {
+	case p.queue <- t:
+		p.lock.RUnlock()
+		return nil
+	default:
+	}
+
+	switch policy.mode {
+	case rejectCallerRuns:
+		p.lock.RUnlock()
+		p.execute(t)
+		return nil
+	case rejectDiscard:
+		p.lock.RUnlock()
+		p.discard(ctx)
+		return nil
+	case rejectDiscardOldest:
+		defer p.lock.RUnlock()
+		if cap(p.queue) == 0 {
+			p.discard(ctx)
+			return nil
+		}
+		for {
+			select {
+			case p.queue <- t:
+				return nil
+			case old := <-p.queue:
+				p.discard(old.ctx)
+			}
+		}
+	case rejectBlock:
+		// 持有读锁等待，停止时quit被关闭，等待的任务被拒绝
+		defer p.lock.RUnlock()
+		var timeout <-chan time.Time
+		if policy.timeout > 0 {
+			timer := time.NewTimer(policy.timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case p.queue <- t:
+			return nil
+		case <-timeout:
+			return p.reject(ErrPoolFull)
+		case <-p.quit:
+			return p.reject(ErrPoolStopped)
+		}
+	default:
+		p.lock.RUnlock()
+		return p.reject(ErrPoolFull)
+	}
+}
+
+// 停止协程池：拒绝新的任务，等待已接受的任务全部执行结束
+func (p *WorkerPool) Stop() {
+	p.shutdown(false)
+}
+
+// 立即停止协程池：拒绝新的任务，丢弃等待中的任务并等待正在执行的任务结束
+// 被丢弃的阶段任务通过RejectTask以ErrPoolStopped异常结束
+func (p *WorkerPool) StopNow() {
+	p.shutdown(true)
+}
+
+func (p *WorkerPool) shutdown(discard bool) {
+	if discard {
+		atomic.StoreInt32(&p.discarding, 1)
+	}
+	p.stopOnce.Do(func() {
+		close(p.quit)
+		// 等待正在入队的任务返回后，不再有新任务入队
+		p.lock.Lock()
+		p.stopped = true
+		p.lock.Unlock()
+		close(p.sealed)
+	})
+	p.wg.Wait()
+}
+
+// 获得协程池当前的运行统计
+func (p *WorkerPool) Metrics() PoolMetrics {
+	return p.metrics(int64(len(p.queue)))
+}
+
+func (p *WorkerPool) loop() {
+	defer p.wg.Done()
+	for {
+		// 优先检查是否已停止，StopNow后不再执行队列中的任务
+		select {
+		case <-p.quit:
+			<-p.sealed
+			p.drain()
+			return
+		default:
+		}
+		select {
+		case t := <-p.queue:
+			p.execute(t)
+		case <-p.quit:
+			<-p.sealed
+			p.drain()
+			return
+		}
+	}
+}
+
+// 停止后不再有新任务入队，处理剩余任务后退出
+func (p *WorkerPool) drain() {
+	for {
+		t, ok := p.drainOne()
+		if !ok {
+			return
+		}
+		p.execute(t)
+	}
+}
+
+// 停止后获取一个剩余任务，StopNow时丢弃剩余任务
+func (p *WorkerPool) drainOne() (poolTask, bool) {
+	for {
+		select {
+		case t := <-p.queue:
+			if atomic.LoadInt32(&p.discarding) == 1 {
+				p.drop(t.ctx)
+				continue
+			}
+			return t, true
+		default:
+			return poolTask{}, false
+		}
+	}
+}
+
+// 补偿阻塞时获取任务，停止后协助处理剩余任务
+func (p *WorkerPool) takeTask(stop <-chan struct{}) (poolTask, bool) {
+	select {
+	case <-stop:
+		return poolTask{}, false
+	default:
+	}
+	select {
+	case t := <-p.queue:
+		return t, true
+	case <-stop:
+		return poolTask{}, false
+	case <-p.quit:
+	}
+	<-p.sealed
+	return p.drainOne()
+}
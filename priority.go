@@ -0,0 +1,386 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"container/heap"
+	"context"
+	"github.com/xfali/executor"
+	"sync"
+	"time"
+)
+
+const (
+	// PriorityExecutor默认的老化时间
+	DefaultPriorityAging = 100 * time.Millisecond
+)
+
+type priorityKey struct{}
+
+// 获得阶段任务的优先级，ctx为协程池RunContext或RunPolicy传入的context
+// 阶段未指定优先级时返回false
+func TaskPriority(ctx context.Context) (int, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	p, ok := ctx.Value(priorityKey{}).(int)
+	return p, ok
+}
+
+type priorityTask struct {
+	poolTask
+	// 排序值，越小越优先：入队时间 - 优先级 * 老化时间
+	rank int64
+	seq  uint64
+}
+
+type priorityQueue []*priorityTask
+
+func (q priorityQueue) Len() int { return len(q) }
+
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].rank != q[j].rank {
+		return q[i].rank < q[j].rank
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q priorityQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *priorityQueue) Push(x interface{}) {
+	*q = append(*q, x.(*priorityTask))
+}
+
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	ret := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return ret
+}
+
+// 按优先级执行任务的协程池，实现executor.Executor及PolicyExecutor
+// 1、优先级数值越大越优先，相同优先级按提交顺序执行
+// 2、阶段的优先级通过WithPriority选项指定（后续阶段默认继承），
+// 或通过PriorityExecutor.WithPriority获得指定默认优先级的协程池视图，未指定时为0
+// 3、老化：任务每等待一个老化时间，其优先级相当于提高1，避免低优先级任务饥饿
+// 4、队列已满时按拒绝策略处理，DiscardOldestPolicy丢弃队列中最不优先的任务
+// 5、工作协程阻塞等待其他阶段时按BlockingMode补偿（默认BlockingSpawn）
+type PriorityExecutor struct {
+	poolBase
+	queueSize int
+	aging     time.Duration
+	policy    RejectionPolicy
+
+	lock  sync.Mutex
+	cond  *sync.Cond
+	queue priorityQueue
+	seq   uint64
+	// 等待任务的空闲工作协程数
+	idle int
+	// 队列有空位时关闭并重建，唤醒BlockPolicy等待的任务
+	space   chan struct{}
+	stopped bool
+	quit    chan struct{}
+}
+
+type PriorityOpt func(p *PriorityExecutor)
+
+// 设置协程池的拒绝策略，阶段通过WithRejectionPolicy指定的策略优先
+func PriorityRejectionPolicy(policy RejectionPolicy) PriorityOpt {
+	return func(p *PriorityExecutor) {
+		p.policy = policy
+	}
+}
+
+// 设置老化时间，默认为DefaultPriorityAging，小于等于0时不老化（低优先级任务可能饥饿）
+func PriorityAging(aging time.Duration) PriorityOpt {
+	return func(p *PriorityExecutor) {
+		p.aging = aging
+	}
+}
+
+// 设置工作协程阻塞等待其他阶段时的补偿方式
+func PriorityBlockingMode(mode BlockingMode) PriorityOpt {
+	return func(p *PriorityExecutor) {
+		p.blocking = mode
+	}
+}
+
+// 设置补偿阻塞时临时工作协程数的上限，含义同PoolMaxSpawn
+func PriorityMaxSpawn(n int) PriorityOpt {
+	return func(p *PriorityExecutor) {
+		p.setMaxSpawn(n)
+	}
+}
+
+// 设置任务panic时的处理函数，含义同PoolPanicHandler
+func PriorityPanicHandler(f func(v interface{}, stack []byte)) PriorityOpt {
+	return func(p *PriorityExecutor) {
+		p.panicHandler = f
+	}
+}
+
+// 创建按优先级执行任务的协程池
+// Param：workers 工作协程数，小于1时为1
+// Param：queueSize 等待队列长度，含义同NewWorkerPool
+func NewPriorityExecutor(workers, queueSize int, opts ...PriorityOpt) *PriorityExecutor {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	ret := &PriorityExecutor{
+		queueSize: queueSize,
+		aging:     DefaultPriorityAging,
+		policy:    AbortPolicy,
+		space:     make(chan struct{}),
+		quit:      make(chan struct{}),
+	}
+	ret.poolBase = poolBase{pool: ret, size: workers, panicHandler: logPoolPanic, maxSpawn: DefaultMaxSpawn}
+	ret.cond = sync.NewCond(&ret.lock)
+	for _, opt := range opts {
+		opt(ret)
+	}
+	ret.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go ret.loop()
+	}
+	return ret
+}
+
+// 获得指定默认优先级的协程池视图，阶段自身指定了优先级时使用阶段的优先级
+// 视图的Stop不做任何操作，需通过PriorityExecutor的Stop停止
+func (p *PriorityExecutor) WithPriority(priority int) executor.Executor {
+	return &priorityView{
+		owner:    p,
+		priority: priority,
+	}
+}
+
+func (p *PriorityExecutor) Run(task executor.Task) error {
+	return p.RunPolicy(context.Background(), task, RejectionPolicy{})
+}
+
+// 执行一个任务，ctx被取消时丢弃尚未执行的任务
+func (p *PriorityExecutor) RunContext(ctx context.Context, task executor.Task) error {
+	return p.RunPolicy(ctx, task, RejectionPolicy{})
+}
+
+// 执行一个任务，优先级通过TaskPriority从ctx获得，队列已满时按policy处理
+func (p *PriorityExecutor) RunPolicy(ctx context.Context, task executor.Task, policy RejectionPolicy) error {
+	priority, _ := TaskPriority(ctx)
+	return p.runPriority(ctx, task, priority, policy)
+}
+
+func (p *PriorityExecutor) runPriority(ctx context.Context, task executor.Task, priority int, policy RejectionPolicy) error {
+	if policy.mode == rejectDefault {
+		policy = p.policy
+	}
+	t := &priorityTask{
+		poolTask: poolTask{ctx: ctx, task: task},
+		rank:     time.Now().UnixNano() - int64(priority)*int64(p.aging),
+	}
+	if p.aging <= 0 {
+		t.rank = -int64(priority)
+	}
+
+	var deadline <-chan time.Time
+	for {
+		p.lock.Lock()
+		if p.stopped {
+			p.lock.Unlock()
+			return p.reject(ErrPoolStopped)
+		}
+		// 空闲工作协程直接取走任务，不占用等待队列
+		if len(p.queue) < p.queueSize+p.idle {
+			p.push(t)
+			p.lock.Unlock()
+			return nil
+		}
+
+		switch policy.mode {
+		case rejectCallerRuns:
+			p.lock.Unlock()
+			p.execute(t.poolTask)
+			return nil
+		case rejectDiscard:
+			p.lock.Unlock()
+			p.discard(ctx)
+			return nil
+		case rejectDiscardOldest:
+			if len(p.queue) == 0 {
+				p.lock.Unlock()
+				p.discard(ctx)
+				return nil
+			}
+			// 丢弃最不优先的任务，新任务最不优先时丢弃新任务
+			last := 0
+			for i := range p.queue {
+				if p.queue.Less(last, i) {
+					last = i
+				}
+			}
+			old := p.queue[last]
+			if old.rank <= t.rank {
+				p.lock.Unlock()
+				p.discard(ctx)
+				return nil
+			}
+			heap.Remove(&p.queue, last)
+			p.push(t)
+			p.lock.Unlock()
+			p.discard(old.ctx)
+			return nil
+		case rejectBlock:
+			space := p.space
+			p.lock.Unlock()
+			if deadline == nil && policy.timeout > 0 {
+				timer := time.NewTimer(policy.timeout)
+				defer timer.Stop()
+				deadline = timer.C
+			}
+			select {
+			case <-space:
+			case <-deadline:
+				return p.reject(ErrPoolFull)
+			case <-p.quit:
+				return p.reject(ErrPoolStopped)
+			}
+		default:
+			p.lock.Unlock()
+			return p.reject(ErrPoolFull)
+		}
+	}
+}
+
+// 需持有锁
+func (p *PriorityExecutor) push(t *priorityTask) {
+	p.seq++
+	t.seq = p.seq
+	heap.Push(&p.queue, t)
+	p.cond.Signal()
+}
+
+// 停止协程池：拒绝新的任务，等待已接受的任务全部执行结束
+func (p *PriorityExecutor) Stop() {
+	p.shutdown(false)
+}
+
+// 立即停止协程池：拒绝新的任务，丢弃等待中的任务并等待正在执行的任务结束
+// 被丢弃的阶段任务通过RejectTask以ErrPoolStopped异常结束
+func (p *PriorityExecutor) StopNow() {
+	p.shutdown(true)
+}
+
+func (p *PriorityExecutor) shutdown(discard bool) {
+	p.lock.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.quit)
+	}
+	var dropped priorityQueue
+	if discard {
+		dropped = p.queue
+		p.queue = nil
+	}
+	p.cond.Broadcast()
+	p.lock.Unlock()
+
+	for _, t := range dropped {
+		p.drop(t.ctx)
+	}
+	p.wg.Wait()
+}
+
+// 获得协程池当前的运行统计
+func (p *PriorityExecutor) Metrics() PoolMetrics {
+	p.lock.Lock()
+	queued := len(p.queue)
+	p.lock.Unlock()
+	return p.metrics(int64(queued))
+}
+
+func (p *PriorityExecutor) loop() {
+	defer p.wg.Done()
+	for {
+		p.lock.Lock()
+		for len(p.queue) == 0 && !p.stopped {
+			p.idle++
+			p.freeSpace()
+			p.cond.Wait()
+			p.idle--
+		}
+		// 已停止且队列为空
+		if len(p.queue) == 0 {
+			p.lock.Unlock()
+			return
+		}
+		t := p.pop()
+		p.lock.Unlock()
+		p.execute(t.poolTask)
+	}
+}
+
+// 需持有锁
+func (p *PriorityExecutor) pop() *priorityTask {
+	t := heap.Pop(&p.queue).(*priorityTask)
+	p.freeSpace()
+	return t
+}
+
+// 唤醒BlockPolicy等待的任务，需持有锁
+func (p *PriorityExecutor) freeSpace() {
+	close(p.space)
+	p.space = make(chan struct{})
+}
+
+func (p *PriorityExecutor) takeTask(stop <-chan struct{}) (poolTask, bool) {
+	return pollTask(stop, func() (poolTask, bool) {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if len(p.queue) == 0 {
+			return poolTask{}, false
+		}
+		return p.pop().poolTask, true
+	})
+}
+
+type priorityView struct {
+	owner    *PriorityExecutor
+	priority int
+}
+
+func (v *priorityView) Run(task executor.Task) error {
+	return v.RunPolicy(context.Background(), task, RejectionPolicy{})
+}
+
+func (v *priorityView) RunContext(ctx context.Context, task executor.Task) error {
+	return v.RunPolicy(ctx, task, RejectionPolicy{})
+}
+
+func (v *priorityView) RunPolicy(ctx context.Context, task executor.Task, policy RejectionPolicy) error {
+	priority, ok := TaskPriority(ctx)
+	if !ok {
+		priority = v.priority
+	}
+	return v.owner.runPriority(ctx, task, priority, policy)
+}
+
+func (v *priorityView) Stop() {}
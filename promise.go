@@ -0,0 +1,146 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xfali/completable/functools"
+	"reflect"
+)
+
+// 由外部完成的CompletionStage的控制器
+type Resolver interface {
+	// 给予值并正常结束，如果已经完成则返回错误
+	Resolve(v interface{}) error
+
+	// 发送panic，异常结束，如果已经完成则返回错误
+	Reject(v interface{}) error
+
+	// 取消阶段
+	// 如果任务已完成返回false，成功取消返回true
+	Cancel() bool
+
+	// 指定取消原因取消阶段
+	// 如果任务已完成返回false，成功取消返回true
+	CancelWithCause(cause error) bool
+
+	// 重置为未完成状态，之后可以再次Resolve或Reject
+	// 已经读取原结果的后续阶段不受影响，已取消的阶段不能重置
+	Reset() error
+}
+
+type defaultResolver struct {
+	cf *defaultCompletableFuture
+	vh *defaultValueHandler
+}
+
+// 创建未完成的CompletionStage，由返回的Resolver完成
+// Param：t 阶段结果类型，为nil时为interface{}
+// Param：opts 阶段选项，指定的协程池由后续阶段继承
+// Return：未完成的CompletionStage及其Resolver
+func NewCompletableFuture(t reflect.Type, opts ...Option) (CompletionStage, Resolver) {
+	return defaultEngine.NewCompletableFuture(t, opts...)
+}
+
+// 创建结果类型未知的未完成CompletionStage，由返回的Resolver完成
+// 结果类型由第一次Resolve的值确定，因此创建下一阶段时不检查参数函数的参数类型，
+// 参数函数执行时按实际结果类型转换，类型不匹配时下一阶段panic
+func NewPromise(opts ...Option) (CompletionStage, Resolver) {
+	return defaultEngine.NewPromise(opts...)
+}
+
+// 将回调风格的API转换为CompletionStage
+// Param：f 参数函数，同步调用，resolve正常结束，reject异常结束，f本身panic时异常结束
+// Return：结果类型未知的CompletionStage，参考NewPromise
+func FromCallback(f func(resolve func(v interface{}), reject func(v interface{}))) CompletionStage {
+	cf, resolver := NewPromise()
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				resolver.Reject(r)
+			}
+		}()
+		f(func(v interface{}) {
+			resolver.Resolve(v)
+		}, func(v interface{}) {
+			resolver.Reject(v)
+		})
+	}()
+	return cf
+}
+
+func (r *defaultResolver) Resolve(v interface{}) error {
+	rv, err := valueOf(r.cf.convertMode(), r.vh.Type(), v)
+	if err != nil {
+		return err
+	}
+	if v != nil {
+		r.vh.resolveType(rv.Type())
+	}
+	return r.vh.SetValueOrError(vOrErr{
+		v:      rv,
+		status: vOrErrNormal,
+	})
+}
+
+func (r *defaultResolver) Reject(v interface{}) error {
+	return r.vh.SetValueOrError(vOrErr{
+		v: &panicMsg{
+			origin: v,
+			trace:  r.cf.engine.stacks(),
+		},
+		status: vOrErrPanic,
+	})
+}
+
+func (r *defaultResolver) Cancel() bool {
+	return r.cf.Cancel()
+}
+
+func (r *defaultResolver) CancelWithCause(cause error) bool {
+	return r.cf.CancelWithCause(cause)
+}
+
+func (r *defaultResolver) Reset() error {
+	if r.cf.ctx.Err() != nil {
+		return errors.New("Cancelled stage cannot be reset. ")
+	}
+	r.vh.reset()
+	return nil
+}
+
+// 将值转换为类型为t的reflect.Value，nil转换为t的零值
+// t为nil（阶段类型未知）时使用值本身的类型
+func valueOf(mode functools.ConvertMode, t reflect.Type, v interface{}) (reflect.Value, error) {
+	if t == nil {
+		if v == nil {
+			return functools.NilValue, nil
+		}
+		return reflect.ValueOf(v), nil
+	}
+	if v == nil {
+		return reflect.Zero(t), nil
+	}
+	rv := reflect.ValueOf(v)
+	if !mode.TypeMatch(rv.Type(), t) {
+		return reflect.Value{}, fmt.Errorf("Type not match. expect: %s get %s . ", t.String(), rv.Type().String())
+	}
+	ret := reflect.New(t).Elem()
+	ret.Set(mode.ConvertValue(rv, t))
+	return ret, nil
+}
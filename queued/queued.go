@@ -115,10 +115,11 @@ const (
 )
 
 type stage struct {
-	other    completable.CompletionStage
-	value    interface{}
-	fn       interface{}
-	executor executor.Executor
+	other completable.CompletionStage
+	value interface{}
+	fn    interface{}
+	// 协程池及阶段选项，原样传递给实际的阶段
+	executor []executor.Executor
 	cfType   Type
 }
 
@@ -211,7 +212,7 @@ func (cf *queuedCompletableFuture) ThenRun(runnable interface{}) completable.Com
 
 // 当阶段正常完成时执行参数函数：不关心上一步结果
 // Param：参数函数: f func()
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) ThenRunAsync(runnable interface{}, executor ...executor.Executor) completable.CompletionStage {
 	stage := &stage{
@@ -228,7 +229,6 @@ func (cf *queuedCompletableFuture) ThenRunAsync(runnable interface{}, executor .
 // Param：参数函数，combineFunc func(TYPE1, TYPE2) TYPE3参数为两个CompletionStage的结果，返回转化结果
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) ThenCombine(other completable.CompletionStage, combineFunc interface{}) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType: TypeThenCombine,
 		fn:     combineFunc,
@@ -241,10 +241,9 @@ func (cf *queuedCompletableFuture) ThenCombine(other completable.CompletionStage
 // 当阶段正常完成时执行参数函数：结合两个CompletionStage的结果，转化后返回
 // Param：other，当该CompletionStage也返回后进行结合转化
 // Param：参数函数，combineFunc func(TYPE1, TYPE2) TYPE3参数为两个CompletionStage的结果，返回转化结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) ThenCombineAsync(other completable.CompletionStage, combineFunc interface{}, executor ...executor.Executor) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType:   TypeThenCombineAsync,
 		fn:       combineFunc,
@@ -260,7 +259,6 @@ func (cf *queuedCompletableFuture) ThenCombineAsync(other completable.Completion
 // Param：参数函数，acceptFunc func(TYPE1, TYPE2) 参数为两个CompletionStage的结果
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) ThenAcceptBoth(other completable.CompletionStage, acceptFunc interface{}) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType: TypeThenAcceptBoth,
 		fn:     acceptFunc,
@@ -273,11 +271,10 @@ func (cf *queuedCompletableFuture) ThenAcceptBoth(other completable.CompletionSt
 // 当阶段正常完成时执行参数函数：结合两个CompletionStage的结果，进行消耗
 // Param：other，当该CompletionStage也返回后进行消耗
 // Param：参数函数，acceptFunc func(TYPE1, TYPE2) 参数为两个CompletionStage的结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) ThenAcceptBothAsync(
 	other completable.CompletionStage, acceptFunc interface{}, executor ...executor.Executor) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType:   TypeThenAcceptBothAsync,
 		fn:       acceptFunc,
@@ -293,7 +290,6 @@ func (cf *queuedCompletableFuture) ThenAcceptBothAsync(
 // Param：参数函数 runnable func()
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) RunAfterBoth(other completable.CompletionStage, runnable interface{}) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType: TypeRunAfterBoth,
 		fn:     runnable,
@@ -306,10 +302,9 @@ func (cf *queuedCompletableFuture) RunAfterBoth(other completable.CompletionStag
 // 当阶段正常完成时执行参数函数：两个CompletionStage都完成后执行
 // Param：other，当该CompletionStage也完成后执行参数函数
 // Param：参数函数 runnable func()
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) RunAfterBothAsync(other completable.CompletionStage, runnable interface{}, executor ...executor.Executor) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType:   TypeRunAfterBothAsync,
 		fn:       runnable,
@@ -325,7 +320,6 @@ func (cf *queuedCompletableFuture) RunAfterBothAsync(other completable.Completio
 // Param：参数函数 f func(o Type1) Type2参数为先完成的CompletionStage的结果，返回转化结果
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) ApplyToEither(other completable.CompletionStage, applyFunc interface{}) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType: TypeApplyToEither,
 		fn:     applyFunc,
@@ -338,10 +332,9 @@ func (cf *queuedCompletableFuture) ApplyToEither(other completable.CompletionSta
 // 当阶段正常完成时执行参数函数：两个CompletionStage使用先完成的结果进行转化
 // Param：other，与该CompletionStage比较，用先完成的结果进行转化，注意两个CompletionStage的返回结果类型必须相同
 // Param：参数函数 f func(o Type1) Type2参数为先完成的CompletionStage的结果，返回转化结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) ApplyToEitherAsync(other completable.CompletionStage, applyFunc interface{}, executor ...executor.Executor) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType:   TypeApplyToEitherAsync,
 		fn:       applyFunc,
@@ -357,7 +350,6 @@ func (cf *queuedCompletableFuture) ApplyToEitherAsync(other completable.Completi
 // Param：参数函数  f func(o Type)参数为先完成的CompletionStage的结果
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) AcceptEither(other completable.CompletionStage, acceptFunc interface{}) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType: TypeAcceptEither,
 		fn:     acceptFunc,
@@ -370,10 +362,9 @@ func (cf *queuedCompletableFuture) AcceptEither(other completable.CompletionStag
 // 当阶段正常完成时执行参数函数：两个CompletionStage使用先完成的结果进行消耗
 // Param：other，与该CompletionStage比较，用先完成的结果进行消耗，注意两个CompletionStage的返回结果类型必须相同
 // Param：参数函数 f func(o Type)参数为先完成的CompletionStage的结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) AcceptEitherAsync(other completable.CompletionStage, acceptFunc interface{}, executor ...executor.Executor) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType:   TypeAcceptEitherAsync,
 		fn:       acceptFunc,
@@ -389,7 +380,6 @@ func (cf *queuedCompletableFuture) AcceptEitherAsync(other completable.Completio
 // Param：参数函数
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) RunAfterEither(other completable.CompletionStage, runnable interface{}) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType: TypeRunAfterEither,
 		fn:     runnable,
@@ -402,10 +392,9 @@ func (cf *queuedCompletableFuture) RunAfterEither(other completable.CompletionSt
 // 当阶段正常完成时执行参数函数：两个CompletionStage任意一个完成则执行操作
 // Param：other，与该CompletionStage比较，任意一个完成则执行操作，注意两个CompletionStage的返回结果类型必须相同
 // Param：参数函数
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) RunAfterEitherAsync(other completable.CompletionStage, runnable interface{}, executor ...executor.Executor) completable.CompletionStage {
-	cf.checkType(other)
 	stage := &stage{
 		cfType:   TypeRunAfterEitherAsync,
 		fn:       runnable,
@@ -430,7 +419,7 @@ func (cf *queuedCompletableFuture) ThenCompose(f interface{}) completable.Comple
 
 // 当阶段正常完成时执行参数函数：使用上一阶段结果转化为新的CompletionStage
 // Param：参数函数，f func(o TYPE) completable.CompletionStage 参数：上一阶段结果，返回新的CompletionStage
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) ThenComposeAsync(f interface{}, executor ...executor.Executor) completable.CompletionStage {
 	stage := &stage{
@@ -468,7 +457,7 @@ func (cf *queuedCompletableFuture) WhenComplete(f interface{}) completable.Compl
 
 // 阶段执行时获得结果或者panic,注意会继续传递panic
 // Param：参数函数，f func(result Type, panic interface{}) 参数result：结果，参数panic：异常
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) WhenCompleteAsync(f interface{}, executor ...executor.Executor) completable.CompletionStage {
 	stage := &stage{
@@ -494,7 +483,7 @@ func (cf *queuedCompletableFuture) Handle(f interface{}) completable.CompletionS
 
 // 阶段执行时获得结果或者panic,并转化结果
 // Param：参数函数，f func(result TYPE1, panic interface{}) TYPE2 参数result：结果，参数panic：异常，返回：转化的结果
-// Param：Executor: 异步执行的协程池，如果不填则使用内置默认协程池
+// Param：Executor: 异步执行的协程池及阶段选项（Option），如果不填则使用内置默认协程池
 // Return：新的CompletionStage
 func (cf *queuedCompletableFuture) HandleAsync(f interface{}, executor ...executor.Executor) completable.CompletionStage {
 	stage := &stage{
@@ -524,15 +513,30 @@ func (cf *queuedCompletableFuture) CompleteExceptionally(v interface{}) error {
 	return cf.origin.CompleteExceptionally(v)
 }
 
+// 强制设置队列中最后一个阶段的值
+func (cf *queuedCompletableFuture) ObtrudeValue(v interface{}) error {
+	return cf.join().ObtrudeValue(v)
+}
+
+// 强制设置队列中最后一个阶段的panic
+func (cf *queuedCompletableFuture) ObtrudeException(cause interface{}) error {
+	return cf.join().ObtrudeException(cause)
+}
+
 // 取消并打断stage链，退出任务
 // 如果任务已完成返回false，成功取消返回true
 func (cf *queuedCompletableFuture) Cancel() bool {
+	return cf.CancelWithCause(nil)
+}
+
+// 指定取消原因，取消并打断stage链，退出任务
+func (cf *queuedCompletableFuture) CancelWithCause(cause error) bool {
 	cf.changeStatus(statusCancel)
 	cf.setInterrupter(func(c completable.CompletionStage) bool {
-		c.Cancel()
+		c.CancelWithCause(cause)
 		return true
 	})
-	return cf.origin.Cancel()
+	return cf.origin.CancelWithCause(cause)
 }
 
 func (cf *queuedCompletableFuture) changeStatus(status int32) {
@@ -541,13 +545,34 @@ func (cf *queuedCompletableFuture) changeStatus(status int32) {
 
 // 是否在完成前被取消
 func (cf *queuedCompletableFuture) IsCancelled() bool {
-	return cf.origin.IsCancelled()
+	return cf.State() == completable.Cancelled
 }
 
 // 是否任务完成
 // 当任务正常完成，被取消，抛出异常都会返回true
 func (cf *queuedCompletableFuture) IsDone() bool {
-	return cf.join().IsDone()
+	return cf.State().IsTerminal()
+}
+
+// 是否异常结束，异常或被取消都会返回true
+func (cf *queuedCompletableFuture) IsCompletedExceptionally() bool {
+	s := cf.State()
+	return s == completable.Failed || s == completable.Cancelled
+}
+
+// 获得阶段当前的生命周期状态，即队列中最后一个阶段的状态
+func (cf *queuedCompletableFuture) State() completable.State {
+	return cf.join().State()
+}
+
+// 返回队列中最后一个阶段结束时关闭的channel
+func (cf *queuedCompletableFuture) Done() <-chan struct{} {
+	return cf.join().Done()
+}
+
+// 非阻塞获得队列中最后一个阶段的结果，阶段未结束时返回false
+func (cf *queuedCompletableFuture) Result() (completable.ValueOrError, bool) {
+	return cf.join().Result()
 }
 
 // 等待并获得任务执行结果
@@ -557,9 +582,21 @@ func (cf *queuedCompletableFuture) Get(result interface{}, timeout ...time.Durat
 	return cf.join().Get(result, timeout...)
 }
 
-func CompletedFuture(value interface{}) (retCf completable.CompletionStage) {
+// 立即返回队列中最后一个阶段的结果，不阻塞
+// 阶段已结束时与Get相同，否则将valueIfAbsent设置到result
+func (cf *queuedCompletableFuture) GetNow(valueIfAbsent interface{}, result interface{}) error {
+	return cf.join().GetNow(valueIfAbsent, result)
+}
+
+// 立即返回队列中最后一个阶段的结果，不阻塞
+// 阶段已结束时返回true，错误与Get相同；阶段未结束时返回false
+func (cf *queuedCompletableFuture) TryGet(result interface{}) (bool, error) {
+	return cf.join().TryGet(result)
+}
+
+func CompletedFuture(value interface{}, opts ...completable.Option) (retCf completable.CompletionStage) {
 	ret := &queuedCompletableFuture{
-		origin: completable.CompletedFuture(value),
+		origin: completable.CompletedFuture(value, opts...),
 		queue:  list.New(),
 	}
 	return ret
@@ -582,6 +619,10 @@ func RunAsync(f func(), executor ...executor.Executor) (retCf completable.Comple
 }
 
 func AllOf(cfs ...completable.CompletionStage) (retCf completable.CompletionStage) {
+	return AllOfWithOptions(cfs)
+}
+
+func AllOfWithOptions(cfs []completable.CompletionStage, opts ...completable.Option) (retCf completable.CompletionStage) {
 	if len(cfs) == 0 {
 		return nil
 	}
@@ -596,7 +637,28 @@ func AllOf(cfs ...completable.CompletionStage) (retCf completable.CompletionStag
 	}
 
 	ret := &queuedCompletableFuture{
-		origin: completable.AllOf(origins...),
+		origin: completable.AllOfWithOptions(origins, opts...),
+		queue:  list.New(),
+	}
+	return ret
+}
+
+func AnyOfWithOptions(cfs []completable.CompletionStage, opts ...completable.Option) (retCf completable.CompletionStage) {
+	if len(cfs) == 0 {
+		return nil
+	}
+
+	origins := make([]completable.CompletionStage, len(cfs))
+	for i := range cfs {
+		if v, ok := cfs[i].(*queuedCompletableFuture); ok {
+			origins[i] = v.join()
+		} else {
+			origins[i] = cfs[i]
+		}
+	}
+
+	ret := &queuedCompletableFuture{
+		origin: completable.AnyOfWithOptions(origins, opts...),
 		queue:  list.New(),
 	}
 	return ret
@@ -621,28 +683,8 @@ func (cf *queuedCompletableFuture) join() completable.CompletionStage {
 	return cf.result
 }
 
-func (cf *queuedCompletableFuture) chooseExecutor(executor ...executor.Executor) executor.Executor {
-	if len(executor) > 0 {
-		return executor[0]
-	}
-	return nil
-}
-
-func (cf *queuedCompletableFuture) checkType(completable completable.CompletionStage) {
-	checkType(completable)
-}
-
-func checkType(completable completable.CompletionStage) {
-	if _, ok := completable.(*queuedCompletableFuture); !ok {
-		panic("expect queuedCompletableFuture, not match")
-	}
-}
-
-func toQueued(completable completable.CompletionStage) *queuedCompletableFuture {
-	if v, ok := completable.(*queuedCompletableFuture); ok {
-		return v
-	}
-	panic("expect queuedCompletableFuture, not match")
+func (cf *queuedCompletableFuture) chooseExecutor(executor ...executor.Executor) []executor.Executor {
+	return executor
 }
 
 func (cf *queuedCompletableFuture) setInterrupter(interrupter interrupter) {
@@ -659,11 +701,12 @@ func (cf *queuedCompletableFuture) getInterrupter() interrupter {
 	return cf.interrupter
 }
 
+// 其他实现的阶段原样返回，由默认实现负责转换
 func runStage(completable completable.CompletionStage) completable.CompletionStage {
 	if v, ok := completable.(*queuedCompletableFuture); ok {
-		return v.convertOrigin()
+		return v.join()
 	}
-	panic("expect queuedCompletableFuture, not match")
+	return completable
 }
 
 func (cf *queuedCompletableFuture) convertOrigin() completable.CompletionStage {
@@ -681,53 +724,53 @@ func (cf *queuedCompletableFuture) convertOrigin() completable.CompletionStage {
 		case TypeThenApply:
 			cur = cur.ThenApply(stage.fn)
 		case TypeThenApplyAsync:
-			cur = cur.ThenApplyAsync(stage.fn, stage.executor)
+			cur = cur.ThenApplyAsync(stage.fn, stage.executor...)
 		case TypeThenAccept:
 			cur = cur.ThenAccept(stage.fn)
 		case TypeThenAcceptAsync:
-			cur = cur.ThenAcceptAsync(stage.fn, stage.executor)
+			cur = cur.ThenAcceptAsync(stage.fn, stage.executor...)
 		case TypeThenRun:
 			cur = cur.ThenRun(stage.fn)
 		case TypeThenRunAsync:
-			cur = cur.ThenRunAsync(stage.fn, stage.executor)
+			cur = cur.ThenRunAsync(stage.fn, stage.executor...)
 		case TypeThenCombine:
 			cur = cur.ThenCombine(runStage(stage.other), stage.fn)
 		case TypeThenCombineAsync:
-			cur = cur.ThenCombineAsync(runStage(stage.other), stage.fn, stage.executor)
+			cur = cur.ThenCombineAsync(runStage(stage.other), stage.fn, stage.executor...)
 		case TypeThenAcceptBoth:
 			cur = cur.ThenAcceptBoth(runStage(stage.other), stage.fn)
 		case TypeThenAcceptBothAsync:
-			cur = cur.ThenAcceptBothAsync(runStage(stage.other), stage.fn, stage.executor)
+			cur = cur.ThenAcceptBothAsync(runStage(stage.other), stage.fn, stage.executor...)
 		case TypeRunAfterBoth:
 			cur = cur.RunAfterBoth(runStage(stage.other), stage.fn)
 		case TypeRunAfterBothAsync:
-			cur = cur.RunAfterBothAsync(runStage(stage.other), stage.fn, stage.executor)
+			cur = cur.RunAfterBothAsync(runStage(stage.other), stage.fn, stage.executor...)
 		case TypeApplyToEither:
 			cur = cur.ApplyToEither(runStage(stage.other), stage.fn)
 		case TypeApplyToEitherAsync:
-			cur = cur.ApplyToEitherAsync(runStage(stage.other), stage.fn, stage.executor)
+			cur = cur.ApplyToEitherAsync(runStage(stage.other), stage.fn, stage.executor...)
 		case TypeAcceptEither:
 			cur = cur.AcceptEither(runStage(stage.other), stage.fn)
 		case TypeAcceptEitherAsync:
-			cur = cur.AcceptEitherAsync(runStage(stage.other), stage.fn, stage.executor)
+			cur = cur.AcceptEitherAsync(runStage(stage.other), stage.fn, stage.executor...)
 		case TypeRunAfterEither:
 			cur = cur.RunAfterEither(runStage(stage.other), stage.fn)
 		case TypeRunAfterEitherAsync:
-			cur = cur.RunAfterEitherAsync(runStage(stage.other), stage.fn, stage.executor)
+			cur = cur.RunAfterEitherAsync(runStage(stage.other), stage.fn, stage.executor...)
 		case TypeThenCompose:
 			cur = cur.ThenCompose(stage.fn)
 		case TypeThenComposeAsync:
-			cur = cur.ThenComposeAsync(stage.fn, stage.executor)
+			cur = cur.ThenComposeAsync(stage.fn, stage.executor...)
 		case TypeExceptionally:
 			cur = cur.Exceptionally(stage.fn)
 		case TypeWhenComplete:
 			cur = cur.WhenComplete(stage.fn)
 		case TypeWhenCompleteAsync:
-			cur = cur.WhenCompleteAsync(stage.fn, stage.executor)
+			cur = cur.WhenCompleteAsync(stage.fn, stage.executor...)
 		case TypeHandle:
 			cur = cur.Handle(stage.fn)
 		case TypeHandleAsync:
-			cur = cur.HandleAsync(stage.fn, stage.executor)
+			cur = cur.HandleAsync(stage.fn, stage.executor...)
 		default:
 			panic(fmt.Sprintln("cannot handle type: ", stage.cfType))
 		}
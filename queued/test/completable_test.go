@@ -17,6 +17,8 @@
 package test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/xfali/completable"
 	"github.com/xfali/completable/queued"
@@ -144,6 +146,28 @@ func TestAllOf(t *testing.T) {
 }
 
 func TestAnyOf(t *testing.T) {
+	t.Run("options", func(t *testing.T) {
+		cf := queued.AnyOfWithOptions([]completable.CompletionStage{queued.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 2
+		}), queued.SupplyAsync(func() int {
+			return 1
+		})}, completable.WithName("any"))
+		now := time.Now()
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if time.Since(now) > 500*time.Millisecond {
+			t.Fatal("must complete with the first stage")
+		}
+
+		pending, _ := completable.NewPromise()
+		cf = queued.AnyOfWithOptions([]completable.CompletionStage{pending}, completable.WithTimeout(50*time.Millisecond))
+		if err := cf.Get(nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expect DeadlineExceeded but get ", err)
+		}
+	})
+
 	t.Run("normal", func(t *testing.T) {
 		now := time.Now()
 		cf := queued.AnyOf(queued.SupplyAsync(func() int {
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import "context"
+
+// 返回CompletionStage的只读视图
+// 只读视图可以进行链式操作及Get，Complete及CompleteExceptionally返回*ReadOnlyError，Cancel返回false
+// 从只读视图派生的阶段被取消时，不会取消原阶段
+// 只读视图与原阶段共享结果及context，状态完全由原阶段决定
+func ReadOnly(stage CompletionStage) CompletionStage {
+	if cf, ok := stage.(*defaultCompletableFuture); ok {
+		cf.checkValue()
+		// 视图不能取消，派生阶段的取消（引用计数及CancelChain）在视图处停止
+		ret := newCfWithCancel(cf.engine, cf.ctx, func(cause error) {}, cf.v.(*defaultValueHandler))
+		ret.readOnly = true
+		ret.name = cf.name
+		ret.panicPolicy = cf.panicPolicy
+		ret.exec = cf.exec
+		ret.priority = cf.priority
+		return ret
+	}
+	if _, ok := stage.(*readOnlyStage); ok {
+		return stage
+	}
+	return &readOnlyStage{
+		CompletionStage: stage,
+	}
+}
+
+// ReadOnly的别名
+func MinimalStage(stage CompletionStage) CompletionStage {
+	return ReadOnly(stage)
+}
+
+// 非默认实现的只读视图
+type readOnlyStage struct {
+	CompletionStage
+}
+
+func (s *readOnlyStage) Complete(v interface{}) error {
+	return &ReadOnlyError{Op: "Complete"}
+}
+
+func (s *readOnlyStage) CompleteExceptionally(v interface{}) error {
+	return &ReadOnlyError{Op: "CompleteExceptionally"}
+}
+
+func (s *readOnlyStage) ObtrudeValue(v interface{}) error {
+	return &ReadOnlyError{Op: "ObtrudeValue"}
+}
+
+func (s *readOnlyStage) ObtrudeException(cause interface{}) error {
+	return &ReadOnlyError{Op: "ObtrudeException"}
+}
+
+func (s *readOnlyStage) Cancel() bool {
+	return false
+}
+
+func (s *readOnlyStage) CancelWithCause(cause error) bool {
+	return false
+}
+
+// 汇合后的阶段同样为只读视图
+func (s *readOnlyStage) JoinCompletionStage(ctx context.Context) CompletionStage {
+	if joinable, ok := s.CompletionStage.(Joinable); ok {
+		return ReadOnly(joinable.JoinCompletionStage(ctx))
+	}
+	return s
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xfali/executor"
+	"time"
+)
+
+var ErrRejected = errors.New("Task rejected. ")
+
+// 任务被协程池拒绝的错误，errors.Is(err, ErrRejected)为true
+type RejectedError struct {
+	Cause error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("Task rejected: %v", e.Cause)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+func newRejectedError(cause error) error {
+	if errors.Is(cause, ErrRejected) {
+		return cause
+	}
+	return &RejectedError{Cause: cause}
+}
+
+type rejectMode int
+
+const (
+	rejectDefault rejectMode = iota
+	rejectAbort
+	rejectCallerRuns
+	rejectDiscard
+	rejectDiscardOldest
+	rejectBlock
+)
+
+// 任务被协程池拒绝（队列已满等）时的处理策略，零值表示使用协程池的策略，协程池未指定时为AbortPolicy
+type RejectionPolicy struct {
+	mode    rejectMode
+	timeout time.Duration
+}
+
+var (
+	// 拒绝任务，阶段以ErrRejected异常结束
+	AbortPolicy = RejectionPolicy{mode: rejectAbort}
+	// 在提交任务的协程中直接执行
+	CallerRunsPolicy = RejectionPolicy{mode: rejectCallerRuns}
+	// 丢弃任务，阶段以ErrRejected为原因被取消
+	DiscardPolicy = RejectionPolicy{mode: rejectDiscard}
+	// 丢弃等待时间最长的任务后重新提交，被丢弃任务的阶段以ErrRejected为原因被取消
+	// 不支持PolicyExecutor的协程池无法访问其队列，等同于DiscardPolicy
+	DiscardOldestPolicy = RejectionPolicy{mode: rejectDiscardOldest}
+)
+
+// 等待协程池可以接受任务，超时后与AbortPolicy相同
+// 只等待ErrPoolFull（队列已满），协程池已停止等其他错误与AbortPolicy相同；阶段被取消时停止等待
+// Param：timeout 最长等待时间，小于等于0时一直等待
+func BlockPolicy(timeout time.Duration) RejectionPolicy {
+	return RejectionPolicy{mode: rejectBlock, timeout: timeout}
+}
+
+func (p RejectionPolicy) String() string {
+	switch p.mode {
+	case rejectAbort:
+		return "Abort"
+	case rejectCallerRuns:
+		return "CallerRuns"
+	case rejectDiscard:
+		return "Discard"
+	case rejectDiscardOldest:
+		return "DiscardOldest"
+	case rejectBlock:
+		return fmt.Sprintf("Block(%s)", p.timeout)
+	default:
+		return "Default"
+	}
+}
+
+// 支持拒绝策略的协程池扩展接口
+// 阶段的任务通过RunPolicy提交，协程池按策略处理无法接受的任务；丢弃已接受的任务时调用DiscardTask或RejectTask
+type PolicyExecutor interface {
+	ContextExecutor
+
+	// 执行一个任务，policy为零值时使用协程池自身的策略
+	RunPolicy(ctx context.Context, task executor.Task, policy RejectionPolicy) error
+}
+
+// 不支持PolicyExecutor的协程池返回错误时，按阶段的策略处理
+// Param：ctx 任务的ctx，被取消时停止等待
+// Param：runFn 重新提交任务
+// Param：run 任务本身，CallerRuns时直接执行
+// Param：discard 丢弃任务
+func applyRejection(ctx context.Context, policy RejectionPolicy, err error, runFn func() error, run func(), discard func()) error {
+	switch policy.mode {
+	case rejectCallerRuns:
+		run()
+		return nil
+	case rejectDiscard, rejectDiscardOldest:
+		discard()
+		return nil
+	case rejectBlock:
+		var deadline time.Time
+		if policy.timeout > 0 {
+			deadline = time.Now().Add(policy.timeout)
+		}
+		wait := time.Millisecond
+		for deadline.IsZero() || time.Now().Before(deadline) {
+			// 其他错误（如协程池已停止）不会因等待而恢复
+			if !errors.Is(err, ErrPoolFull) {
+				return err
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+			if err = runFn(); err == nil {
+				return nil
+			}
+			if wait < 50*time.Millisecond {
+				wait *= 2
+			}
+		}
+		return err
+	default:
+		return err
+	}
+}
@@ -0,0 +1,328 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package completable
+
+import (
+	"context"
+	"github.com/xfali/executor"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// 工作窃取协程池的任务，fork不为nil时为Fork提交的任务
+type stealTask struct {
+	poolTask
+	fork func(ctx context.Context)
+}
+
+// 获得由协程池p的工作协程w执行的任务，w为nil时为补偿协程
+// 阶段任务的taskOwner记录w，Fork提交的任务获得记录了w的任务context
+func (t stealTask) bind(p *WorkStealingPool, w *stealWorker) poolTask {
+	if t.fork == nil {
+		ret := t.poolTask
+		ret.worker = w
+		return ret
+	}
+	owner := &taskOwner{}
+	ctx := context.WithValue(t.ctx, ownerKey{}, owner)
+	fork := t.fork
+	return poolTask{
+		ctx: t.ctx,
+		task: func() {
+			owner.begin(p, w)
+			defer owner.end()
+			fork(ctx)
+		},
+	}
+}
+
+// 双端队列，所属工作协程从尾部存取（后进先出），其他工作协程从头部窃取（先进先出）
+type taskDeque struct {
+	lock  sync.Mutex
+	tasks []stealTask
+}
+
+func (d *taskDeque) pushBottom(t stealTask) {
+	d.lock.Lock()
+	d.tasks = append(d.tasks, t)
+	d.lock.Unlock()
+}
+
+func (d *taskDeque) popBottom() (stealTask, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	n := len(d.tasks)
+	if n == 0 {
+		return stealTask{}, false
+	}
+	t := d.tasks[n-1]
+	d.tasks[n-1] = stealTask{}
+	d.tasks = d.tasks[:n-1]
+	return t, true
+}
+
+func (d *taskDeque) popTop() (stealTask, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.tasks) == 0 {
+		return stealTask{}, false
+	}
+	t := d.tasks[0]
+	d.tasks[0] = stealTask{}
+	d.tasks = d.tasks[1:]
+	return t, true
+}
+
+func (d *taskDeque) size() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.tasks)
+}
+
+type stealWorker struct {
+	pool  *WorkStealingPool
+	index int
+	deque taskDeque
+}
+
+// ForkJoin风格的工作窃取协程池，实现executor.Executor及ContextExecutor
+// 1、每个工作协程拥有一个双端队列，工作协程中提交的任务放入该工作协程队列尾部，优先执行最近提交的任务，数据局部性更好
+// 工作协程通过任务的context识别：Fork任务的ctx，或ThenCompose参数函数的ctx参数，
+// 以其（或其派生ctx）提交的任务，以及以其通过WithContext创建的阶段及后续阶段的任务
+// 2、其他任务放入共享队列
+// 3、工作协程自身队列为空时先从共享队列获取，再从其他工作协程队列的头部窃取任务
+// 4、工作协程阻塞等待其他阶段时按BlockingMode补偿（默认BlockingSpawn）
+// 队列不限长度，适用于分治类的递归任务
+type WorkStealingPool struct {
+	poolBase
+	workers []*stealWorker
+	shared  taskDeque
+
+	lock sync.Mutex
+	cond *sync.Cond
+	// 等待执行的任务数
+	pending int64
+	// 等待任务的工作协程数
+	idle int64
+
+	// 提交任务时持有读锁，停止时持有写锁，停止后不再有任务入队
+	submitLock sync.RWMutex
+	stopped    int32
+	stopOnce   sync.Once
+
+	stolen int64
+}
+
+type StealingOpt func(p *WorkStealingPool)
+
+// 设置工作协程阻塞等待其他阶段时的补偿方式
+func StealingBlockingMode(mode BlockingMode) StealingOpt {
+	return func(p *WorkStealingPool) {
+		p.blocking = mode
+	}
+}
+
+// 设置补偿阻塞时临时工作协程数的上限，含义同PoolMaxSpawn
+func StealingMaxSpawn(n int) StealingOpt {
+	return func(p *WorkStealingPool) {
+		p.setMaxSpawn(n)
+	}
+}
+
+// 设置任务panic时的处理函数，含义同PoolPanicHandler
+func StealingPanicHandler(f func(v interface{}, stack []byte)) StealingOpt {
+	return func(p *WorkStealingPool) {
+		p.panicHandler = f
+	}
+}
+
+// 创建工作窃取协程池
+// Param：workers 工作协程数，小于1时为runtime.GOMAXPROCS(0)
+func NewWorkStealingPool(workers int, opts ...StealingOpt) *WorkStealingPool {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	ret := &WorkStealingPool{
+		workers: make([]*stealWorker, workers),
+	}
+	ret.poolBase = poolBase{pool: ret, size: workers, panicHandler: logPoolPanic, maxSpawn: DefaultMaxSpawn}
+	ret.cond = sync.NewCond(&ret.lock)
+	for _, opt := range opts {
+		opt(ret)
+	}
+	ret.wg.Add(workers)
+	for i := range ret.workers {
+		w := &stealWorker{pool: ret, index: i}
+		ret.workers[i] = w
+		go ret.loop(w)
+	}
+	return ret
+}
+
+func (p *WorkStealingPool) Run(task executor.Task) error {
+	return p.RunContext(context.Background(), task)
+}
+
+// 执行一个任务，ctx被取消时丢弃尚未执行的任务
+// ctx为本协程池正在执行的任务的context时，任务放入执行该任务的工作协程的队列
+func (p *WorkStealingPool) RunContext(ctx context.Context, task executor.Task) error {
+	return p.push(stealTask{poolTask: poolTask{ctx: ctx, task: task}})
+}
+
+// 提交可以继续拆分的任务（ForkJoin），task执行时获得标记了所属工作协程的ctx，
+// 以该ctx（或其派生ctx）调用Fork或RunContext提交的子任务放入该工作协程队列尾部
+// Param：ctx 任务的ctx，为本协程池正在执行的任务的context时放入对应工作协程的队列，否则放入共享队列
+// Param：task 任务，参数为标记了所属工作协程的ctx
+func (p *WorkStealingPool) Fork(ctx context.Context, task func(ctx context.Context)) error {
+	return p.push(stealTask{poolTask: poolTask{ctx: ctx}, fork: task})
+}
+
+func (p *WorkStealingPool) push(t stealTask) error {
+	p.submitLock.RLock()
+	defer p.submitLock.RUnlock()
+	if atomic.LoadInt32(&p.stopped) == 1 {
+		return p.reject(ErrPoolStopped)
+	}
+	// 先增加计数，等待的工作协程被唤醒后一定可以获得任务
+	atomic.AddInt64(&p.pending, 1)
+	if w := p.owner(t.ctx); w != nil {
+		w.deque.pushBottom(t)
+	} else {
+		p.shared.pushBottom(t)
+	}
+	if atomic.LoadInt64(&p.idle) > 0 {
+		p.lock.Lock()
+		p.cond.Signal()
+		p.lock.Unlock()
+	}
+	return nil
+}
+
+// 获得正在执行ctx所属任务的本协程池工作协程，任务已结束或不在本协程池的工作协程中执行时返回nil
+func (p *WorkStealingPool) owner(ctx context.Context) *stealWorker {
+	if ctx == nil {
+		return nil
+	}
+	if owner, ok := ctx.Value(ownerKey{}).(*taskOwner); ok {
+		if w := owner.currentWorker(); w != nil && w.pool == p {
+			return w
+		}
+	}
+	return nil
+}
+
+// 停止协程池：拒绝新的任务，等待已接受的任务全部执行结束
+// 注意：停止后工作协程中提交的任务同样被拒绝
+func (p *WorkStealingPool) Stop() {
+	p.stopOnce.Do(func() {
+		p.submitLock.Lock()
+		atomic.StoreInt32(&p.stopped, 1)
+		p.submitLock.Unlock()
+		p.lock.Lock()
+		p.cond.Broadcast()
+		p.lock.Unlock()
+	})
+	p.wg.Wait()
+}
+
+// 获得协程池当前的运行统计
+func (p *WorkStealingPool) Metrics() PoolMetrics {
+	return p.metrics(atomic.LoadInt64(&p.pending))
+}
+
+// 获得从其他工作协程队列窃取的任务数
+func (p *WorkStealingPool) Stolen() int64 {
+	return atomic.LoadInt64(&p.stolen)
+}
+
+// 获得每个工作协程队列中等待执行的任务数
+func (p *WorkStealingPool) QueueSizes() []int {
+	ret := make([]int, len(p.workers))
+	for i, w := range p.workers {
+		ret[i] = w.deque.size()
+	}
+	return ret
+}
+
+func (p *WorkStealingPool) loop(w *stealWorker) {
+	defer p.wg.Done()
+	for {
+		if t, ok := p.take(w); ok {
+			atomic.AddInt64(&p.pending, -1)
+			p.execute(t.bind(p, w))
+			continue
+		}
+		if !p.park() {
+			return
+		}
+	}
+}
+
+// 依次从自身队列尾部、共享队列及其他工作协程队列头部获取任务
+func (p *WorkStealingPool) take(w *stealWorker) (stealTask, bool) {
+	if t, ok := w.deque.popBottom(); ok {
+		return t, true
+	}
+	return p.steal(w.index)
+}
+
+// 从共享队列及其他工作协程队列头部获取任务，self为当前工作协程，小于0时从所有工作协程窃取
+func (p *WorkStealingPool) steal(self int) (stealTask, bool) {
+	if t, ok := p.shared.popTop(); ok {
+		return t, true
+	}
+	n := len(p.workers)
+	for i := 1; i <= n; i++ {
+		victim := p.workers[(self+i+n)%n]
+		if victim.index == self {
+			continue
+		}
+		if t, ok := victim.deque.popTop(); ok {
+			atomic.AddInt64(&p.stolen, 1)
+			return t, true
+		}
+	}
+	return stealTask{}, false
+}
+
+// 等待新的任务，已停止且没有等待执行的任务时返回false
+func (p *WorkStealingPool) park() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	atomic.AddInt64(&p.idle, 1)
+	defer atomic.AddInt64(&p.idle, -1)
+	for atomic.LoadInt64(&p.pending) <= 0 {
+		if atomic.LoadInt32(&p.stopped) == 1 {
+			return false
+		}
+		p.cond.Wait()
+	}
+	return true
+}
+
+// 补偿阻塞时从共享队列或工作协程队列窃取任务
+func (p *WorkStealingPool) takeTask(stop <-chan struct{}) (poolTask, bool) {
+	return pollTask(stop, func() (poolTask, bool) {
+		t, ok := p.steal(-1)
+		if !ok {
+			return poolTask{}, false
+		}
+		atomic.AddInt64(&p.pending, -1)
+		return t.bind(p, nil), true
+	})
+}
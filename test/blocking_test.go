@@ -0,0 +1,263 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/executor"
+	"testing"
+	"time"
+)
+
+// 在工作协程中等待同一协程池中排在后面的阶段，不补偿时单工作协程的协程池死锁
+// 等待的阶段以任务的context创建，协程池由此识别工作协程中的等待
+func nestedGet(t *testing.T, exec executor.Executor) {
+	cf := completable.CompletedFuture(1).ThenComposeAsync(func(ctx context.Context, i int) completable.CompletionStage {
+		inner := completable.SupplyAsync(func() int {
+			return i
+		}, exec, completable.WithContext(ctx))
+		var v int
+		if err := inner.Get(&v); err != nil {
+			panic(err)
+		}
+		return completable.CompletedFuture(v + 1)
+	}, exec)
+	var v int
+	if err := cf.Get(&v, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 {
+		t.Fatal("expect 2 but get ", v)
+	}
+}
+
+// 在工作协程中同步合并两个排在后面的阶段
+func nestedCombine(t *testing.T, exec executor.Executor) {
+	cf := completable.SupplyAsync(func() int {
+		return 1
+	}, exec).ThenComposeAsync(func(ctx context.Context, i int) completable.CompletionStage {
+		a := completable.SupplyAsync(func() int {
+			return i + 1
+		}, exec, completable.WithContext(ctx))
+		b := completable.SupplyAsync(func() int {
+			return i + 2
+		}, exec, completable.WithContext(ctx))
+		return a.ThenCombine(b, func(x, y int) int {
+			return x + y
+		})
+	}, exec)
+	var v int
+	if err := cf.Get(&v, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if v != 5 {
+		t.Fatal("expect 5 but get ", v)
+	}
+}
+
+// 递归的斐波那契数列，合并在工作协程中阻塞等待，子问题以任务的context创建
+func blockingFib(ctx context.Context, n int, exec executor.Executor) completable.CompletionStage {
+	return completable.CompletedFuture(n, completable.WithContext(ctx)).ThenComposeAsync(func(ctx context.Context, n int) completable.CompletionStage {
+		if n < 8 {
+			return completable.CompletedFuture(seqFib(n))
+		}
+		var v int
+		if err := blockingFib(ctx, n-1, exec).ThenCombine(blockingFib(ctx, n-2, exec), func(a, b int) int {
+			return a + b
+		}).Get(&v); err != nil {
+			panic(err)
+		}
+		return completable.CompletedFuture(v)
+	}, exec)
+}
+
+func TestManagedBlocking(t *testing.T) {
+	t.Run("spawn", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 16)
+		nestedGet(t, pool)
+		nestedCombine(t, pool)
+		pool.Stop()
+		if m := pool.Metrics(); m.Blocked != 0 {
+			t.Fatal("blocked workers must be 0 but get ", m.Blocked)
+		}
+	})
+
+	t.Run("run inline", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 16, completable.PoolBlockingMode(completable.BlockingRunInline))
+		nestedGet(t, pool)
+		nestedCombine(t, pool)
+		pool.Stop()
+	})
+
+	t.Run("blocked metrics", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 16)
+		defer pool.Stop()
+		release := make(chan struct{})
+		gate := completable.SupplyAsync(func() int {
+			<-release
+			return 1
+		})
+		cf := completable.CompletedFuture(1).ThenComposeAsync(func(ctx context.Context, i int) completable.CompletionStage {
+			completable.AllOfWithOptions([]completable.CompletionStage{gate}, completable.WithContext(ctx))
+			return gate
+		}, pool)
+		for i := 0; pool.Metrics().Blocked != 1; i++ {
+			if i > 100 {
+				t.Fatal("expect 1 blocked worker")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		// 阻塞期间由临时工作协程执行其他任务
+		if err := completable.RunAsync(func() {}, pool).Get(nil, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		close(release)
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if m := pool.Metrics(); m.Blocked != 0 {
+			t.Fatal("blocked workers must be 0 but get ", m.Blocked)
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 16, completable.PoolBlockingMode(completable.BlockingFail))
+		defer pool.Stop()
+		var err error
+		cf := completable.CompletedFuture(1).ThenComposeAsync(func(ctx context.Context, i int) completable.CompletionStage {
+			inner := completable.SupplyAsync(func() int {
+				return i
+			}, pool, completable.WithPanicPolicy(completable.PanicAsError), completable.WithContext(ctx))
+			err = inner.Get(nil)
+			return completable.CompletedFuture(i)
+		}, pool)
+		if e := cf.Get(nil); e != nil {
+			t.Fatal(e)
+		}
+		if !errors.Is(err, completable.ErrWorkerBlocked) {
+			t.Fatal("expect ErrWorkerBlocked but get ", err)
+		}
+		var be *completable.BlockingError
+		if !errors.As(err, &be) || be.Workers != 1 || be.Blocked != 1 || len(be.Stack) == 0 {
+			t.Fatal("blocking error not match ", be)
+		}
+
+		// *Async任务等待上一阶段是内部等待，不受BlockingFail限制
+		combine := completable.SupplyAsync(func() int {
+			time.Sleep(50 * time.Millisecond)
+			return 1
+		}).ThenCombineAsync(completable.CompletedFuture(1), func(a, b int) int {
+			return a + b
+		}, pool, completable.WithPanicPolicy(completable.PanicAsError))
+		var v int
+		if err := combine.Get(&v); err != nil || v != 2 {
+			t.Fatal("expect 2 but get ", v, err)
+		}
+		apply := completable.SupplyAsync(func() int {
+			time.Sleep(50 * time.Millisecond)
+			return 1
+		}, pool).ThenApplyAsync(func(i int) int {
+			return i + 1
+		})
+		if err := apply.Get(&v); err != nil || v != 2 {
+			t.Fatal("expect 2 but get ", v, err)
+		}
+
+		// 任务结束后等待以任务的context创建的阶段是普通等待
+		var later completable.CompletionStage
+		cf = completable.CompletedFuture(1).ThenComposeAsync(func(ctx context.Context, i int) completable.CompletionStage {
+			later = completable.SupplyAsync(func() int {
+				time.Sleep(50 * time.Millisecond)
+				return i
+			}, completable.WithContext(ctx), completable.WithPanicPolicy(completable.PanicAsError))
+			return completable.CompletedFuture(i)
+		}, pool)
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := later.Get(&v); err != nil || v != 1 {
+			t.Fatal("expect 1 but get ", v, err)
+		}
+
+		// 已结束的阶段不阻塞
+		cf = completable.CompletedFuture(1).ThenComposeAsync(func(ctx context.Context, i int) completable.CompletionStage {
+			done := completable.CompletedFuture(i, completable.WithContext(ctx))
+			var v int
+			done.Get(&v)
+			return done
+		}, pool)
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("max spawn", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 16, completable.PoolMaxSpawn(2))
+		gate, resolver := completable.NewPromise()
+		cfs := make([]completable.CompletionStage, 0, 6)
+		for i := 0; i < 6; i++ {
+			// 任务等待gate是内部等待，每个等待都需要补偿
+			cfs = append(cfs, gate.ThenApplyAsync(func(v interface{}) interface{} {
+				return v
+			}, pool))
+		}
+		for i := 0; pool.Metrics().Blocked != 6; i++ {
+			if i > 100 {
+				t.Fatal("expect 6 blocked workers ", pool.Metrics())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		// 超过上限的等待在临时工作协程中执行其余任务
+		if m := pool.Metrics(); m.Spawned != 2 || m.Queued != 0 {
+			t.Fatal("temporary workers must be bounded ", m)
+		}
+		resolver.Resolve(1)
+		for _, cf := range cfs {
+			var v int
+			if err := cf.Get(&v, time.Second); err != nil || v != 1 {
+				t.Fatal("expect 1 but get ", v, err)
+			}
+		}
+		pool.Stop()
+		if m := pool.Metrics(); m.Spawned != 0 || m.Blocked != 0 {
+			t.Fatal("metrics not match ", m)
+		}
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		exec := completable.NewPriorityExecutor(1, 16)
+		nestedGet(t, exec)
+		nestedCombine(t, exec)
+		exec.Stop()
+	})
+
+	t.Run("work stealing", func(t *testing.T) {
+		for _, mode := range []completable.BlockingMode{completable.BlockingSpawn, completable.BlockingRunInline} {
+			pool := completable.NewWorkStealingPool(2, completable.StealingBlockingMode(mode))
+			var v int
+			if err := blockingFib(context.Background(), 15, pool).Get(&v, 10*time.Second); err != nil {
+				t.Fatal(mode, err)
+			}
+			if v != seqFib(15) {
+				t.Fatal(mode, " expect ", seqFib(15), " but get ", v)
+			}
+			pool.Stop()
+		}
+	})
+}
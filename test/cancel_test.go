@@ -0,0 +1,268 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/lazycompletable"
+	"github.com/xfali/completable/queued"
+	"testing"
+	"time"
+)
+
+var errDisconnected = errors.New("user disconnected")
+
+func TestCancelWithCause(t *testing.T) {
+	t.Run("get", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		dependent := cf.ThenApplyAsync(func(i int) int {
+			return i + 1
+		})
+		if !cf.CancelWithCause(errDisconnected) {
+			t.Fatal("must be cancelled")
+		}
+		for _, stage := range []completable.CompletionStage{cf, dependent} {
+			err := stage.Get(nil)
+			var cancelErr *completable.CancellationError
+			if !errors.As(err, &cancelErr) || cancelErr.Index != -1 {
+				t.Fatal("must be CancellationError, got", err)
+			}
+			if !errors.Is(err, errDisconnected) {
+				t.Fatal("cause not match", err)
+			}
+		}
+	})
+
+	t.Run("no cause", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		cf.Cancel()
+		if err := cf.Get(nil); !errors.Is(err, context.Canceled) {
+			t.Fatal("cause must be context.Canceled, got", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		err := cf.Get(nil, 10*time.Millisecond)
+		var timeoutErr *completable.TimeoutError
+		if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expect TimeoutError, got", err)
+		}
+		var cancelErr *completable.CancellationError
+		if errors.As(err, &cancelErr) || cf.IsCancelled() {
+			t.Fatal("timeout must not cancel the stage")
+		}
+	})
+
+	t.Run("handle", func(t *testing.T) {
+		origin := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		causes := make(chan error, 1)
+		origin.HandleAsync(func(i int, o interface{}) int {
+			if err, ok := o.(*completable.CancellationError); ok {
+				causes <- err.Cause
+			} else {
+				causes <- nil
+			}
+			return 0
+		})
+		origin.CancelWithCause(errDisconnected)
+		select {
+		case cause := <-causes:
+			if cause != errDisconnected {
+				t.Fatal("Handle must receive CancellationError, got", cause)
+			}
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("timeout")
+		}
+	})
+
+	t.Run("all of", func(t *testing.T) {
+		cf1 := completable.CompletedFuture(1)
+		cf2 := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 2
+		})
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cf2.CancelWithCause(errDisconnected)
+		}()
+		err := completable.AllOf(cf1, cf2).Get(nil)
+		var cancelErr *completable.CancellationError
+		if !errors.As(err, &cancelErr) || cancelErr.Index != 1 {
+			t.Fatal("must be cancelled by stage 1, got", err)
+		}
+		if cancelErr.Cause != errDisconnected {
+			t.Fatal("cause not match", err)
+		}
+	})
+
+	t.Run("any of", func(t *testing.T) {
+		cf1 := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		cf2, resolver := completable.NewPromise()
+		resolver.CancelWithCause(errDisconnected)
+		err := completable.AnyOf(cf1, cf2).Get(nil)
+		var cancelErr *completable.CancellationError
+		if !errors.As(err, &cancelErr) || cancelErr.Index != 1 {
+			t.Fatal("must be cancelled by stage 1, got", err)
+		}
+		if cancelErr.Cause != errDisconnected {
+			t.Fatal("cause not match", err)
+		}
+	})
+
+	t.Run("lazy and queued", func(t *testing.T) {
+		stages := []completable.CompletionStage{
+			lazycompletable.SupplyAsync(func() int {
+				time.Sleep(time.Second)
+				return 1
+			}),
+			queued.SupplyAsync(func() int {
+				time.Sleep(time.Second)
+				return 1
+			}),
+		}
+		for _, cf := range stages {
+			cf = cf.ThenApply(func(i int) int {
+				return i + 1
+			})
+			go func(cf completable.CompletionStage) {
+				time.Sleep(50 * time.Millisecond)
+				cf.CancelWithCause(errDisconnected)
+			}(cf)
+			if err := cf.Get(nil); !errors.Is(err, errDisconnected) {
+				t.Fatal("cause not match", err)
+			}
+		}
+	})
+}
+
+func TestCancelMode(t *testing.T) {
+	newRoot := func() completable.CompletionStage {
+		return completable.SupplyAsync(func() int {
+			time.Sleep(200 * time.Millisecond)
+			return 1
+		})
+	}
+	apply := func(i int) int {
+		return i + 1
+	}
+
+	t.Run("branch", func(t *testing.T) {
+		root := newRoot()
+		leaf1 := root.ThenApplyAsync(apply)
+		leaf2 := root.ThenApplyAsync(apply)
+		leaf1.Cancel()
+		if !leaf1.IsCancelled() {
+			t.Fatal("leaf1 must be cancelled")
+		}
+		if root.IsCancelled() || leaf2.IsCancelled() {
+			t.Fatal("root and sibling must not be cancelled")
+		}
+		ret := 0
+		if err := leaf2.Get(&ret); err != nil || ret != 2 {
+			t.Fatal("not match", err, ret)
+		}
+	})
+
+	t.Run("reference counted", func(t *testing.T) {
+		root := newRoot()
+		middle := root.ThenApplyAsync(apply)
+		leaf1 := middle.ThenApplyAsync(apply)
+		leaf2 := middle.ThenApplyAsync(apply)
+		leaf1.CancelWithCause(errDisconnected)
+		if middle.IsCancelled() {
+			t.Fatal("middle must not be cancelled while leaf2 is alive")
+		}
+		leaf2.CancelWithCause(errDisconnected)
+		if !middle.IsCancelled() || !root.IsCancelled() {
+			t.Fatal("upstream must be cancelled when all dependents are cancelled")
+		}
+		if err := root.Get(nil); !errors.Is(err, errDisconnected) {
+			t.Fatal("cause not match", err)
+		}
+	})
+
+	t.Run("completed dependent", func(t *testing.T) {
+		root, resolver := completable.NewPromise()
+		leaf := root.ThenApplyAsync(func(v interface{}) interface{} {
+			return v
+		})
+		leaf.Complete(1)
+		if leaf.Cancel() {
+			t.Fatal("completed stage cannot be cancelled")
+		}
+		if root.IsCancelled() {
+			t.Fatal("root must not be cancelled")
+		}
+		resolver.Resolve(1)
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		completable.SetCancelMode(completable.CancelChain)
+		defer completable.SetCancelMode(completable.CancelBranch)
+
+		root := newRoot()
+		leaf1 := root.ThenApplyAsync(apply)
+		leaf2 := root.ThenApplyAsync(apply)
+		leaf1.Cancel()
+		if err := leaf2.Get(nil); err == nil {
+			t.Fatal("sibling must be cancelled in CancelChain mode")
+		}
+		if !root.IsCancelled() {
+			t.Fatal("root must be cancelled in CancelChain mode")
+		}
+	})
+	t.Run("engine chain", func(t *testing.T) {
+		e := completable.NewEngine(completable.EngineCancelMode(completable.CancelChain))
+		root := e.SupplyAsync(func() int {
+			time.Sleep(200 * time.Millisecond)
+			return 1
+		})
+		leaf1 := root.ThenApplyAsync(apply)
+		leaf2 := root.ThenApplyAsync(apply)
+		leaf1.Cancel()
+		if err := leaf2.Get(nil); err == nil {
+			t.Fatal("sibling must be cancelled in CancelChain mode")
+		}
+
+		// 默认引擎不受影响
+		other := newRoot()
+		branch1 := other.ThenApplyAsync(apply)
+		branch2 := other.ThenApplyAsync(apply)
+		branch1.Cancel()
+		if err := branch2.Get(nil); err != nil || other.IsCancelled() {
+			t.Fatal("default engine must stay in CancelBranch mode")
+		}
+	})
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestComposeFlatten(t *testing.T) {
+	t.Run("nested", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}).ThenComposeAsync(func(i int) completable.CompletionStage {
+			return completable.SupplyAsync(func() int {
+				return i + 1
+			}).ThenCompose(func(i int) completable.CompletionStage {
+				return completable.CompletedFuture(i + 1)
+			})
+		})
+		var v int
+		if err := cf.Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != 3 {
+			t.Fatal("expect 3 but get ", v)
+		}
+		// 展开后的阶段可以继续使用带类型的函数
+		cf.ThenAccept(func(i int) {
+			t.Log(i)
+		})
+	})
+
+	t.Run("typed downstream", func(t *testing.T) {
+		cf := completable.CompletedFuture(1).ThenCompose(func(i int) completable.CompletionStage {
+			return completable.CompletedFuture("hello")
+		})
+		cf.Get(nil)
+		defer func() {
+			if o := recover(); o == nil {
+				t.Fatal("must panic")
+			} else {
+				t.Log(o)
+			}
+		}()
+		cf.ThenApply(func(i int) int {
+			return i
+		})
+	})
+
+	t.Run("type unknown", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(100 * time.Millisecond)
+			return 1
+		}).ThenComposeAsync(func(i int) completable.CompletionStage {
+			return completable.CompletedFuture("hello")
+		})
+		// 类型未确定，创建时不检查，运行时类型不匹配使阶段失败
+		ret := cf.ThenApply(func(i int) int {
+			return i
+		})
+		if !ret.IsCompletedExceptionally() {
+			t.Fatal("must be completed exceptionally")
+		}
+		var s string
+		if err := cf.Get(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s != "hello" {
+			t.Fatal("expect hello but get ", s)
+		}
+	})
+
+	t.Run("cancel outer", func(t *testing.T) {
+		inner := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		cf := completable.CompletedFuture(1).ThenCompose(func(i int) completable.CompletionStage {
+			return inner
+		})
+		cf.CancelWithCause(errDisconnected)
+		select {
+		case <-inner.Done():
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("inner stage not cancelled")
+		}
+		if !inner.IsCancelled() {
+			t.Fatal("inner stage must be cancelled")
+		}
+		var v int
+		err := inner.Get(&v)
+		if !errors.Is(err, errDisconnected) {
+			t.Fatal("expect errDisconnected but get ", err)
+		}
+	})
+
+	t.Run("cancel inner", func(t *testing.T) {
+		inner := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		cf := completable.CompletedFuture(1).ThenCompose(func(i int) completable.CompletionStage {
+			return inner
+		})
+		inner.CancelWithCause(errDisconnected)
+		select {
+		case <-cf.Done():
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("compose stage not cancelled")
+		}
+		if !cf.IsCancelled() {
+			t.Fatal("compose stage must be cancelled")
+		}
+		var v int
+		err := cf.Get(&v)
+		if !errors.Is(err, errDisconnected) {
+			t.Fatal("expect errDisconnected but get ", err)
+		}
+	})
+	t.Run("cancelled then exceptionally", func(t *testing.T) {
+		inner, _ := completable.NewPromise()
+		inner.CancelWithCause(errDisconnected)
+		cf := completable.CompletedFuture(1).ThenCompose(func(i int) completable.CompletionStage {
+			return inner
+		})
+		select {
+		case <-cf.Done():
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("compose stage not cancelled")
+		}
+		// 取消不是panic，Exceptionally不处理，继续传递取消状态
+		handled := cf.Exceptionally(func(o interface{}) int {
+			return 0
+		})
+		var v int
+		if err := handled.Get(&v, 500*time.Millisecond); !errors.Is(err, errDisconnected) {
+			t.Fatal("expect errDisconnected but get ", err)
+		}
+		if !handled.IsCancelled() {
+			t.Fatal("exceptionally stage must be cancelled ", handled.State())
+		}
+	})
+
+	t.Run("context parameter", func(t *testing.T) {
+		cf := completable.CompletedFuture(1).ThenComposeAsync(func(ctx context.Context, i int) completable.CompletionStage {
+			if ctx == nil {
+				panic("context is nil")
+			}
+			return completable.CompletedFuture(i + 1)
+		}).ThenCompose(func(ctx context.Context, i int) completable.CompletionStage {
+			return completable.CompletedFuture(i + 1)
+		})
+		var v int
+		if err := cf.Get(&v); err != nil || v != 3 {
+			t.Fatal("expect 3 but get ", v, err)
+		}
+		defer func() {
+			if o := recover(); o == nil {
+				t.Fatal("must panic")
+			}
+		}()
+		completable.CompletedFuture(1).ThenCompose(func(ctx context.Context, s string) completable.CompletionStage {
+			return completable.CompletedFuture(s)
+		})
+	})
+
+	t.Run("engine executor", func(t *testing.T) {
+		exec := &countingExecutor{}
+		e := completable.NewEngine(completable.EngineExecutor(exec))
+		cf := e.CompletedFuture(1).ThenCompose(func(i int) completable.CompletionStage {
+			return e.CompletedFuture(i + 1)
+		})
+		var v int
+		if err := cf.Get(&v); err != nil || v != 2 {
+			t.Fatal("expect 2 but get ", v, err)
+		}
+		if atomic.LoadInt32(&exec.runs) != 1 {
+			t.Fatal("inner result must be set through the stage executor, runs: ", exec.runs)
+		}
+	})
+
+	t.Run("inner never completes", func(t *testing.T) {
+		e := completable.NewEngine()
+		inner, _ := e.NewCompletableFuture(nil)
+		// 只读视图不会被外部阶段取消
+		cf := e.CompletedFuture(1).ThenCompose(func(i int) completable.CompletionStage {
+			return completable.ReadOnly(inner)
+		})
+		cf.Cancel()
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		if err := e.Shutdown(ctx); err != nil {
+			t.Fatal("waiting inner stage must not block Shutdown: ", err)
+		}
+	})
+}
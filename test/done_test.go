@@ -0,0 +1,141 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/lazycompletable"
+	"github.com/xfali/completable/queued"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDone(t *testing.T) {
+	t.Run("observers", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(100 * time.Millisecond)
+			return 1
+		})
+		if _, ok := cf.Result(); ok {
+			t.Fatal("must not have result")
+		}
+		wait := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				select {
+				case <-cf.Done():
+				case <-time.After(time.Second):
+					t.Error("timeout")
+				}
+			}()
+		}
+		wait.Wait()
+		ve, ok := cf.Result()
+		if !ok || !ve.HaveValue() || ve.GetValue().Interface().(int) != 1 {
+			t.Fatal("not match")
+		}
+		ret := 0
+		if err := cf.Get(&ret); err != nil || ret != 1 {
+			t.Fatal("value must not be consumed", err, ret)
+		}
+		ret = 0
+		if err := cf.ThenApply(func(i int) int { return i + 1 }).Get(&ret); err != nil || ret != 2 {
+			t.Fatal("not match", err, ret)
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			panic("error")
+		})
+		<-cf.Done()
+		ve, ok := cf.Result()
+		if !ok || !ve.HavePanic() || ve.GetPanic().(string) != "error" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			cf.Cancel()
+		}()
+		select {
+		case <-cf.Done():
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("must be done after cancel")
+		}
+		ve, ok := cf.Result()
+		if !ok || !ve.IsDone() {
+			t.Fatal("must be cancelled")
+		}
+	})
+
+	t.Run("compose", func(t *testing.T) {
+		cf := completable.CompletedFuture(1).ThenCompose(func(i int) completable.CompletionStage {
+			return completable.SupplyAsync(func() int {
+				time.Sleep(200 * time.Millisecond)
+				return i + 1
+			})
+		})
+		time.Sleep(50 * time.Millisecond)
+		if _, ok := cf.Result(); ok {
+			t.Fatal("must wait composed stage")
+		}
+		<-cf.Done()
+		ve, ok := cf.Result()
+		if !ok || ve.GetValue().Interface().(int) != 2 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("lazy", func(t *testing.T) {
+		cf := lazycompletable.SupplyAsync(func() int {
+			return 1
+		}).ThenApply(func(i int) int {
+			return i + 1
+		})
+		if _, ok := cf.Result(); ok {
+			t.Fatal("lazy stage must not have result before join")
+		}
+		<-cf.Done()
+		ve, ok := cf.Result()
+		if !ok || ve.GetValue().Interface().(int) != 2 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("queued", func(t *testing.T) {
+		cf := queued.SupplyAsync(func() int {
+			return 1
+		}).ThenApplyAsync(func(i int) int {
+			return i + 1
+		})
+		<-cf.Done()
+		ve, ok := cf.Result()
+		if !ok || ve.GetValue().Interface().(int) != 2 {
+			t.Fatal("not match")
+		}
+	})
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/executor"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 记录提交次数的协程池
+type countingExecutor struct {
+	runs int32
+}
+
+func (e *countingExecutor) Run(task executor.Task) error {
+	atomic.AddInt32(&e.runs, 1)
+	go task()
+	return nil
+}
+
+func (e *countingExecutor) Stop() {}
+
+// 立即触发的时钟
+type instantClock struct{}
+
+func (c instantClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c instantClock) AfterFunc(d time.Duration, f func()) func() bool {
+	go f()
+	return func() bool {
+		return false
+	}
+}
+
+func TestEngine(t *testing.T) {
+	t.Run("isolated executor", func(t *testing.T) {
+		t.Parallel()
+		exec1, exec2 := &countingExecutor{}, &countingExecutor{}
+		e1 := completable.NewEngine(completable.EngineExecutor(exec1))
+		e2 := completable.NewEngine(completable.EngineExecutor(exec2))
+
+		cf := e1.SupplyAsync(func() int {
+			return 1
+		}).ThenApplyAsync(func(i int) int {
+			return i + 1
+		})
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := e2.RunAsync(func() {}).Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(&exec1.runs) != 2 || atomic.LoadInt32(&exec2.runs) != 1 {
+			t.Fatal("expect 2 and 1 but get ", exec1.runs, exec2.runs)
+		}
+	})
+
+	t.Run("panic as error", func(t *testing.T) {
+		t.Parallel()
+		e := completable.NewEngine(completable.EnginePanicPolicy(completable.PanicAsError),
+			completable.EngineLogPanicStacks(nil, false))
+		cf := e.SupplyAsync(func() int {
+			panic(errDisconnected)
+		}).ThenApply(func(i int) int {
+			return i
+		})
+		err := cf.Get(nil)
+		var pe *completable.PanicError
+		if !errors.As(err, &pe) {
+			t.Fatal("expect PanicError but get ", err)
+		}
+		if !errors.Is(err, errDisconnected) {
+			t.Fatal("expect errDisconnected but get ", err)
+		}
+	})
+
+	t.Run("hooks", func(t *testing.T) {
+		t.Parallel()
+		var submitted, completed int32
+		e := completable.NewEngine(completable.EngineHooks(completable.Hooks{
+			OnSubmit: func(stage completable.CompletionStage) {
+				atomic.AddInt32(&submitted, 1)
+			},
+			OnComplete: func(stage completable.CompletionStage, ve completable.ValueOrError) {
+				atomic.AddInt32(&completed, 1)
+			},
+		}))
+		cf := e.SupplyAsync(func() int {
+			return 1
+		}).ThenApply(func(i int) int {
+			return i + 1
+		})
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		// 钩子在结果设置后调用，可能晚于Get返回
+		for i := 0; i < 100 && atomic.LoadInt32(&completed) != 2; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if atomic.LoadInt32(&submitted) != 1 || atomic.LoadInt32(&completed) != 2 {
+			t.Fatal("expect 1 and 2 but get ", submitted, completed)
+		}
+	})
+
+	t.Run("clock", func(t *testing.T) {
+		t.Parallel()
+		e := completable.NewEngine(completable.EngineClock(instantClock{}))
+		cf, _ := e.NewCompletableFuture(nil)
+		err := cf.Get(nil, time.Hour)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expect DeadlineExceeded but get ", err)
+		}
+	})
+
+	t.Run("delayed executor clock", func(t *testing.T) {
+		t.Parallel()
+		e := completable.NewEngine(completable.EngineClock(instantClock{}))
+		ret := 0
+		err := e.SupplyAsync(func() int {
+			return 1
+		}, e.DelayedExecutor(time.Hour)).Get(&ret)
+		if err != nil || ret != 1 {
+			t.Fatal("expect 1 but get ", ret, err)
+		}
+	})
+
+	t.Run("delayed executor engine executor", func(t *testing.T) {
+		t.Parallel()
+		exec := &countingExecutor{}
+		e := completable.NewEngine(completable.EngineClock(instantClock{}), completable.EngineExecutor(exec))
+		ret := 0
+		// 未指定实际执行的协程池时使用引擎的协程池
+		err := e.SupplyAsync(func() int {
+			return 1
+		}, e.DelayedExecutor(time.Hour)).Get(&ret)
+		if err != nil || ret != 1 {
+			t.Fatal("expect 1 but get ", ret, err)
+		}
+		if atomic.LoadInt32(&exec.runs) != 1 {
+			t.Fatal("expect 1 run on engine executor but get ", exec.runs)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		t.Parallel()
+		e := completable.NewEngine()
+		var finished int32
+		e.RunAsync(func() {
+			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt32(&finished, 1)
+		})
+		if err := e.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(&finished) != 1 {
+			t.Fatal("Shutdown must wait in-flight stages")
+		}
+		cf := e.CompletedFuture(1).ThenApplyAsync(func(i int) int {
+			return i
+		})
+		if !cf.IsCompletedExceptionally() {
+			t.Fatal("must be rejected after shutdown")
+		}
+		err := e.RunAsync(func() {}, completable.WithPanicPolicy(completable.PanicAsError)).Get(nil)
+		if !errors.Is(err, completable.ErrEngineShutdown) {
+			t.Fatal("expect ErrEngineShutdown but get ", err)
+		}
+	})
+
+	t.Run("shutdown timeout", func(t *testing.T) {
+		t.Parallel()
+		e := completable.NewEngine()
+		e.RunAsync(func() {
+			time.Sleep(time.Second)
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := e.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expect DeadlineExceeded but get ", err)
+		}
+	})
+}
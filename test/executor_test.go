@@ -0,0 +1,144 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"github.com/xfali/completable"
+	"github.com/xfali/executor"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 单协程顺序执行任务
+type serialExecutor struct {
+	tasks chan executor.Task
+}
+
+func newSerialExecutor() *serialExecutor {
+	ret := &serialExecutor{
+		tasks: make(chan executor.Task, 16),
+	}
+	go func() {
+		for task := range ret.tasks {
+			task()
+		}
+	}()
+	return ret
+}
+
+func (e *serialExecutor) Run(task executor.Task) error {
+	e.tasks <- task
+	return nil
+}
+
+func (e *serialExecutor) Stop() {
+	close(e.tasks)
+}
+
+// 丢弃已取消任务的协程池
+type droppingExecutor struct {
+	*serialExecutor
+	dropped int32
+}
+
+func (e *droppingExecutor) RunContext(ctx context.Context, task executor.Task) error {
+	return e.Run(func() {
+		if ctx.Err() != nil {
+			atomic.AddInt32(&e.dropped, 1)
+			return
+		}
+		task()
+	})
+}
+
+func TestCooperativeCancel(t *testing.T) {
+	t.Run("check before run", func(t *testing.T) {
+		exec := newSerialExecutor()
+		defer exec.Stop()
+
+		blocker := completable.SupplyAsync(func() int {
+			time.Sleep(200 * time.Millisecond)
+			return 1
+		}, exec)
+		var called int32
+		cf := completable.SupplyAsync(func() int {
+			atomic.StoreInt32(&called, 1)
+			return 2
+		}, exec)
+		cf.Cancel()
+		if cf.State() != completable.Cancelled {
+			t.Fatal("must be cancelled, got", cf.State())
+		}
+		blocker.Get(nil)
+		time.Sleep(50 * time.Millisecond)
+		if atomic.LoadInt32(&called) != 0 {
+			t.Fatal("cancelled task must not run user code")
+		}
+	})
+
+	t.Run("dependent", func(t *testing.T) {
+		exec := newSerialExecutor()
+		defer exec.Stop()
+
+		root := completable.SupplyAsync(func() int {
+			time.Sleep(200 * time.Millisecond)
+			return 1
+		}, exec)
+		var called int32
+		cf := root.ThenApplyAsync(func(i int) int {
+			atomic.StoreInt32(&called, 1)
+			return i + 1
+		}, exec)
+		root.Cancel()
+		select {
+		case <-cf.Done():
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("dependent must be cancelled without waiting for the pool")
+		}
+		if !cf.IsCancelled() {
+			t.Fatal("must be cancelled, got", cf.State())
+		}
+		time.Sleep(300 * time.Millisecond)
+		if atomic.LoadInt32(&called) != 0 {
+			t.Fatal("cancelled task must not run user code")
+		}
+	})
+
+	t.Run("context executor", func(t *testing.T) {
+		exec := &droppingExecutor{serialExecutor: newSerialExecutor()}
+		defer exec.Stop()
+
+		blocker := completable.SupplyAsync(func() int {
+			time.Sleep(100 * time.Millisecond)
+			return 1
+		}, exec)
+		cf := completable.SupplyAsync(func() int {
+			return 2
+		}, exec)
+		cf.Cancel()
+		blocker.Get(nil)
+		time.Sleep(50 * time.Millisecond)
+		if atomic.LoadInt32(&exec.dropped) != 1 {
+			t.Fatal("cancelled task must be dropped")
+		}
+		if err := cf.Get(nil); err == nil {
+			t.Fatal("must be cancelled")
+		}
+	})
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/lazycompletable"
+	"github.com/xfali/completable/queued"
+	"github.com/xfali/executor"
+	"testing"
+)
+
+// 记录调用次数的工厂
+type countingFactory struct {
+	completable.Factory
+	supply int
+}
+
+func (f *countingFactory) SupplyAsync(fn interface{}, executor ...executor.Executor) completable.CompletionStage {
+	f.supply++
+	return f.Factory.SupplyAsync(fn, executor...)
+}
+
+func double(factory completable.Factory, i int) completable.CompletionStage {
+	return factory.SupplyAsync(func() int {
+		return i
+	}).ThenApply(func(i int) int {
+		return i * 2
+	})
+}
+
+func TestFactory(t *testing.T) {
+	factories := map[string]completable.Factory{
+		"default": completable.DefaultFactory,
+		"lazy":    lazycompletable.Factory,
+		"queued":  queued.Factory,
+	}
+	for name, factory := range factories {
+		factory := factory
+		t.Run(name, func(t *testing.T) {
+			var v int
+			if err := double(factory, 2).Get(&v); err != nil {
+				t.Fatal(err)
+			}
+			if v != 4 {
+				t.Fatal("expect 4 but get ", v)
+			}
+
+			ran := false
+			cf := factory.AllOf(factory.CompletedFuture(1), factory.RunAsync(func() {
+				ran = true
+			}))
+			if err := cf.Get(nil); err != nil {
+				t.Fatal(err)
+			}
+			if !ran {
+				t.Fatal("RunAsync not run")
+			}
+
+			cf = factory.AnyOf(factory.CompletedFuture(1), factory.CompletedFuture(2))
+			if err := cf.Get(nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("inject", func(t *testing.T) {
+		if completable.GetFactory() != completable.DefaultFactory {
+			t.Fatal("default factory not match")
+		}
+		fake := &countingFactory{Factory: completable.DefaultFactory}
+		completable.SetFactory(fake)
+		defer completable.SetFactory(nil)
+
+		var v int
+		if err := double(completable.GetFactory(), 3).Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != 6 || fake.supply != 1 {
+			t.Fatal("expect 6 and 1 call but get ", v, fake.supply)
+		}
+		completable.SetFactory(nil)
+		if completable.GetFactory() != completable.DefaultFactory {
+			t.Fatal("factory not reset")
+		}
+	})
+}
@@ -0,0 +1,284 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/functools"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type myInt int
+
+type upper struct{}
+
+func (u upper) Upper(s string) string {
+	return strings.ToUpper(s)
+}
+
+func TestCheckFunction(t *testing.T) {
+	t.Run("assignable", func(t *testing.T) {
+		err := functools.CheckApplyFunction(reflect.TypeOf(func(r io.Reader) int { return 0 }), reflect.TypeOf(&bytes.Buffer{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = functools.CheckAcceptFunction(reflect.TypeOf(func(i interface{}) {}), reflect.TypeOf(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("not match", func(t *testing.T) {
+		err := functools.CheckApplyFunction(reflect.TypeOf(func(r io.Reader) int { return 0 }), reflect.TypeOf(""))
+		if err == nil {
+			t.Fatal("must not match")
+		}
+	})
+
+	t.Run("interface not match", func(t *testing.T) {
+		err := functools.CheckApplyFunction(reflect.TypeOf(func(r *strings.Reader) int { return 0 }), reflect.TypeOf((*io.Reader)(nil)).Elem())
+		if err == nil {
+			t.Fatal("interface must not match implementation")
+		}
+		err = functools.CheckAcceptFunction(reflect.TypeOf(func(s string) {}), functools.InterfaceType)
+		if err == nil {
+			t.Fatal("interface{} must not match string")
+		}
+	})
+
+	t.Run("convert", func(t *testing.T) {
+		fn := reflect.TypeOf(func(i int) int { return 0 })
+		if err := functools.CheckApplyFunction(fn, reflect.TypeOf(myInt(1))); err != nil {
+			t.Fatal(err)
+		}
+		if err := functools.CheckApplyFunction(fn, reflect.TypeOf(float64(1))); err == nil {
+			t.Fatal("float64 must not match in ConvertSameKind mode")
+		}
+
+		if err := functools.ConvertAll.CheckApplyFunction(fn, reflect.TypeOf(float64(1))); err != nil {
+			t.Fatal(err)
+		}
+		if err := functools.ConvertAssignable.CheckApplyFunction(fn, reflect.TypeOf(myInt(1))); err == nil {
+			t.Fatal("myInt must not match in ConvertAssignable mode")
+		}
+
+		// 整数到字符串及字符串与[]byte、[]rune之间的转换改变值的含义，ConvertAll同样不匹配
+		str := reflect.TypeOf(func(s string) int { return 0 })
+		if err := functools.ConvertAll.CheckApplyFunction(str, reflect.TypeOf(65)); err == nil {
+			t.Fatal("int must not match string in ConvertAll mode")
+		}
+		if err := functools.ConvertAll.CheckApplyFunction(str, reflect.TypeOf([]byte{})); err == nil {
+			t.Fatal("[]byte must not match string in ConvertAll mode")
+		}
+		if err := functools.ConvertAll.CheckApplyFunction(reflect.TypeOf(func(r []rune) int { return 0 }), reflect.TypeOf("")); err == nil {
+			t.Fatal("string must not match []rune in ConvertAll mode")
+		}
+		if v := functools.ConvertAll.ConvertValue(reflect.ValueOf(65), reflect.TypeOf("")); v.Kind() != reflect.Int {
+			t.Fatal("int must not be converted to string ", v)
+		}
+	})
+
+	t.Run("variadic", func(t *testing.T) {
+		if err := functools.CheckSupplyFunction(reflect.TypeOf(func(s ...string) int { return 0 })); err != nil {
+			t.Fatal(err)
+		}
+		if err := functools.CheckApplyFunction(reflect.TypeOf(func(s ...string) int { return 0 }), reflect.TypeOf("")); err != nil {
+			t.Fatal(err)
+		}
+		if err := functools.CheckApplyFunction(reflect.TypeOf(func(s ...string) int { return 0 }), reflect.TypeOf([]string{})); err != nil {
+			t.Fatal(err)
+		}
+		if err := functools.CheckApplyFunction(reflect.TypeOf(func(s ...string) int { return 0 }), reflect.TypeOf(1)); err == nil {
+			t.Fatal("int must not match")
+		}
+	})
+}
+
+func TestAssignableStage(t *testing.T) {
+	t.Run("interface", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() *bytes.Buffer {
+			return bytes.NewBufferString("Hello world")
+		}).ThenApply(func(r io.Reader) string {
+			b, _ := ioutil.ReadAll(r)
+			return string(b)
+		})
+		ret := ""
+		if err := cf.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "Hello world" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("convert", func(t *testing.T) {
+		cf := completable.CompletedFuture(myInt(10)).ThenApplyAsync(func(i int) int {
+			return i + 1
+		})
+		ret := 0
+		if err := cf.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 11 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("variadic", func(t *testing.T) {
+		cf := completable.CompletedFuture([]int{1, 2, 3}).ThenApply(func(vs ...int) int {
+			sum := 0
+			for _, v := range vs {
+				sum += v
+			}
+			return sum
+		}).ThenApply(func(vs ...int) int {
+			return len(vs)
+		})
+		ret := 0
+		if err := cf.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 1 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("method", func(t *testing.T) {
+		cf := completable.CompletedFuture("Hello world").ThenApply(upper{}.Upper)
+		ret := ""
+		if err := cf.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "HELLO WORLD" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("not function", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("value with one method is not a function")
+			}
+		}()
+		completable.SupplyAsync(errors.New("boom"))
+	})
+
+	t.Run("runnable type unknown", func(t *testing.T) {
+		cf, _ := completable.NewPromise()
+		defer func() {
+			if recover() == nil {
+				t.Fatal("runnable must be checked even if the stage type is unknown")
+			}
+		}()
+		cf.ThenRunAsync(func(i int) {})
+	})
+
+	t.Run("engine convert mode", func(t *testing.T) {
+		e := completable.NewEngine(completable.EngineConvertMode(functools.ConvertAll))
+		ret := 0
+		if err := e.CompletedFuture(1.5).ThenApply(func(i int) int {
+			return i + 1
+		}).Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 2 {
+			t.Fatal("not match", ret)
+		}
+
+		// 默认引擎不受影响
+		defer func() {
+			if recover() == nil {
+				t.Fatal("float64 must not match in ConvertSameKind mode")
+			}
+		}()
+		completable.CompletedFuture(1.5).ThenApply(func(i int) int {
+			return i + 1
+		})
+	})
+}
+
+func TestTuple(t *testing.T) {
+	t.Run("check", func(t *testing.T) {
+		fn := reflect.TypeOf(func() (int, string) { return 0, "" })
+		if err := functools.CheckSupplyFunction(fn); err != nil {
+			t.Fatal(err)
+		}
+		vType := functools.OutType(fn)
+		if !functools.IsTuple(vType) {
+			t.Fatal("must be tuple")
+		}
+		if err := functools.CheckApplyFunction(reflect.TypeOf(func(i int, s string) int { return 0 }), vType); err != nil {
+			t.Fatal(err)
+		}
+		if err := functools.CheckAcceptFunction(reflect.TypeOf(func(tuple functools.Tuple) {}), vType); err != nil {
+			t.Fatal(err)
+		}
+		if err := functools.CheckApplyFunction(reflect.TypeOf(func(s string, i int) int { return 0 }), vType); err == nil {
+			t.Fatal("must not match")
+		}
+	})
+
+	t.Run("spread", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() (int, string) {
+			return 1, "Hello world"
+		}).ThenApply(func(i int, s string) string {
+			return fmt.Sprintf("%d %s", i, s)
+		})
+		ret := ""
+		if err := cf.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "1 Hello world" {
+			t.Fatal("not match", ret)
+		}
+	})
+
+	t.Run("tuple", func(t *testing.T) {
+		cf := completable.CompletedFuture(1).ThenApplyAsync(func(i int) (int, string) {
+			return i + 1, "Hello world"
+		}).ThenApply(func(tuple functools.Tuple) int {
+			return tuple[0].(int) + len(tuple[1].(string))
+		})
+		ret := 0
+		if err := cf.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 13 {
+			t.Fatal("not match", ret)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() (int, string) {
+			return 1, "Hello world"
+		})
+		var ret functools.Tuple
+		if err := cf.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if len(ret) != 2 || ret[0].(int) != 1 || ret[1].(string) != "Hello world" {
+			t.Fatal("not match", ret)
+		}
+	})
+}
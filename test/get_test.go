@@ -0,0 +1,106 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/xfali/completable"
+	"io"
+	"testing"
+)
+
+func TestGetResult(t *testing.T) {
+	t.Run("interface{}", func(t *testing.T) {
+		var ret interface{}
+		if err := completable.CompletedFuture(1).Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret.(int) != 1 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("interface", func(t *testing.T) {
+		var ret io.Reader
+		if err := completable.CompletedFuture(bytes.NewBufferString("Hello world")).Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret.(*bytes.Buffer).String() != "Hello world" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("dynamic value", func(t *testing.T) {
+		ret := 0
+		cf := completable.SupplyAsync(func() interface{} {
+			return 1
+		})
+		if err := cf.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 1 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("convert", func(t *testing.T) {
+		var ret myInt
+		if err := completable.CompletedFuture(1).Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 1 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("nil type", func(t *testing.T) {
+		ret := 10
+		if err := completable.CompletedFuture(nil).Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 10 {
+			t.Fatal("must not be modified")
+		}
+	})
+
+	t.Run("type error", func(t *testing.T) {
+		ret := ""
+		err := completable.CompletedFuture(1).Get(&ret)
+		var typeErr *completable.ResultTypeError
+		if !errors.As(err, &typeErr) {
+			t.Fatal("expect ResultTypeError, got", err)
+		}
+		t.Log(err)
+	})
+
+	t.Run("not pointer", func(t *testing.T) {
+		ret := 0
+		err := completable.CompletedFuture(1).Get(ret)
+		var invalidErr *completable.InvalidResultError
+		if !errors.As(err, &invalidErr) {
+			t.Fatal("expect InvalidResultError, got", err)
+		}
+
+		var nilPtr *int
+		err = completable.CompletedFuture(1).Get(nilPtr)
+		if !errors.As(err, &invalidErr) {
+			t.Fatal("expect InvalidResultError, got", err)
+		}
+		t.Log(err)
+	})
+}
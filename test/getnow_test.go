@@ -0,0 +1,141 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/lazycompletable"
+	"github.com/xfali/completable/queued"
+	"testing"
+	"time"
+)
+
+func TestGetNow(t *testing.T) {
+	t.Run("absent", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(200 * time.Millisecond)
+			return 1
+		})
+		time.Sleep(50 * time.Millisecond)
+		ret := 0
+		if err := cf.GetNow(-1, &ret); err != nil || ret != -1 {
+			t.Fatal("not match", err, ret)
+		}
+		ret = 10
+		if err := cf.GetNow(nil, &ret); err != nil || ret != 0 {
+			t.Fatal("nil must set zero value", err, ret)
+		}
+		if cf.State() != completable.Running {
+			t.Fatal("GetNow must not change state, got", cf.State())
+		}
+		ret = 0
+		if err := cf.Get(&ret); err != nil || ret != 1 {
+			t.Fatal("not match", err, ret)
+		}
+		if err := cf.GetNow(-1, &ret); err != nil || ret != 1 {
+			t.Fatal("not match", err, ret)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			panic("error")
+		})
+		<-cf.Done()
+		defer func() {
+			if o := recover(); o == nil || o.(string) != "error" {
+				t.Fatal("must panic")
+			}
+		}()
+		ret := 0
+		cf.GetNow(-1, &ret)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		cf.Cancel()
+		ret := 0
+		if err := cf.GetNow(-1, &ret); err == nil {
+			t.Fatal("must be cancelled")
+		}
+	})
+
+	t.Run("lazy", func(t *testing.T) {
+		cf := lazycompletable.SupplyAsync(func() int {
+			return 1
+		})
+		ret := 0
+		if err := cf.GetNow(-1, &ret); err != nil || ret != -1 {
+			t.Fatal("not match", err, ret)
+		}
+		if cf.State() != completable.Pending {
+			t.Fatal("GetNow must not run lazy stage")
+		}
+	})
+
+	t.Run("queued", func(t *testing.T) {
+		cf := queued.CompletedFuture(1).ThenApply(func(i int) int {
+			return i + 1
+		})
+		ret := 0
+		if err := cf.GetNow(-1, &ret); err != nil || ret != 2 {
+			t.Fatal("not match", err, ret)
+		}
+	})
+}
+
+func TestTryGet(t *testing.T) {
+	cf := completable.SupplyAsync(func() string {
+		time.Sleep(100 * time.Millisecond)
+		return "Hello world"
+	})
+	ret := ""
+	ok, err := cf.TryGet(&ret)
+	if ok || err != nil || ret != "" {
+		t.Fatal("must not be done", ok, err, ret)
+	}
+	<-cf.Done()
+	ok, err = cf.TryGet(&ret)
+	if !ok || err != nil || ret != "Hello world" {
+		t.Fatal("not match", ok, err, ret)
+	}
+
+	lazy := lazycompletable.CompletedFuture(1)
+	if ok, err := lazy.TryGet(nil); ok || err != nil {
+		t.Fatal("lazy stage must not be done before join")
+	}
+}
+
+func TestGetTimeout(t *testing.T) {
+	cf := completable.SupplyAsync(func() int {
+		time.Sleep(200 * time.Millisecond)
+		return 1
+	})
+	ret := 0
+	if err := cf.Get(&ret, 10*time.Millisecond); err == nil {
+		t.Fatal("must timeout")
+	}
+	if cf.IsDone() {
+		t.Fatal("timeout must not change state")
+	}
+	if err := cf.Get(&ret); err != nil || ret != 1 {
+		t.Fatal("not match", err, ret)
+	}
+}
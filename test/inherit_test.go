@@ -0,0 +1,84 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"github.com/xfali/completable"
+	"sync/atomic"
+	"testing"
+)
+
+func TestInheritExecutor(t *testing.T) {
+	add := func(i int) int {
+		return i + 1
+	}
+
+	t.Run("chain", func(t *testing.T) {
+		exec := &countingExecutor{}
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, exec).ThenApply(add).ThenApplyAsync(add).ThenAcceptAsync(func(i int) {})
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(&exec.runs) != 3 {
+			t.Fatal("expect 3 but get ", exec.runs)
+		}
+	})
+
+	t.Run("override", func(t *testing.T) {
+		exec1, exec2 := &countingExecutor{}, &countingExecutor{}
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, exec1).ThenApplyAsync(add, exec2).ThenApplyAsync(add)
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(&exec1.runs) != 1 || atomic.LoadInt32(&exec2.runs) != 2 {
+			t.Fatal("expect 1 and 2 but get ", exec1.runs, exec2.runs)
+		}
+	})
+
+	t.Run("combine", func(t *testing.T) {
+		exec := &countingExecutor{}
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, exec).ThenCombineAsync(completable.CompletedFuture(2), func(a, b int) int {
+			return a + b
+		})
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(&exec.runs) != 2 {
+			t.Fatal("expect 2 but get ", exec.runs)
+		}
+	})
+
+	t.Run("opt out", func(t *testing.T) {
+		exec := &countingExecutor{}
+		e := completable.NewEngine(completable.EngineInheritExecutor(false))
+		cf := e.SupplyAsync(func() int {
+			return 1
+		}, exec).ThenApplyAsync(add)
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(&exec.runs) != 1 {
+			t.Fatal("expect 1 but get ", exec.runs)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xfali/completable"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedExecutor(t *testing.T) {
+	t.Run("order", func(t *testing.T) {
+		exec := completable.NewKeyedExecutor(4, 128)
+		defer exec.Stop()
+		lock := sync.Mutex{}
+		got := map[string][]int{}
+		var stages []completable.CompletionStage
+		for i := 0; i < 50; i++ {
+			for _, key := range []string{"a", "b", "c"} {
+				i, key := i, key
+				stages = append(stages, completable.RunAsyncKeyed(exec, key, func() {
+					lock.Lock()
+					defer lock.Unlock()
+					got[key] = append(got[key], i)
+				}))
+			}
+		}
+		if err := completable.AllOf(stages...).Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		for key, list := range got {
+			if len(list) != 50 {
+				t.Fatal(key, " expect 50 tasks but get ", len(list))
+			}
+			for i, v := range list {
+				if i != v {
+					t.Fatal(key, " out of order ", list)
+				}
+			}
+		}
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		exec := completable.NewKeyedExecutor(8, 4)
+		defer exec.Stop()
+		keys := []string{"x", "y"}
+		for i := 0; exec.KeyMetrics(keys[0]).Lane == exec.KeyMetrics(keys[1]).Lane; i++ {
+			keys[1] = fmt.Sprintf("y%d", i)
+		}
+		release := make(chan struct{})
+		started := make(chan struct{}, 2)
+		for _, key := range keys {
+			if err := exec.RunKey(key, func() {
+				started <- struct{}{}
+				<-release
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		select {
+		case <-started:
+			<-started
+		case <-time.After(time.Second):
+			t.Fatal("different lanes must run in parallel")
+		}
+		close(release)
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		exec := completable.NewKeyedExecutor(2, 2)
+		release := make(chan struct{})
+		started := make(chan struct{})
+		view := exec.WithKey(1)
+		if err := view.Run(func() {
+			close(started)
+			<-release
+		}); err != nil {
+			t.Fatal(err)
+		}
+		<-started
+		for i := 0; i < 2; i++ {
+			if err := view.Run(func() {}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := view.Run(func() {}); !errors.Is(err, completable.ErrPoolFull) {
+			t.Fatal("expect ErrPoolFull but get ", err)
+		}
+		m := exec.KeyMetrics(1)
+		if m.Active != 1 || m.Queued != 2 {
+			t.Fatal("metrics not match ", m)
+		}
+		if all := exec.AllKeyMetrics(); len(all) != 1 || all[1] != m {
+			t.Fatal("metrics not match ", all)
+		}
+		if lm := exec.Metrics()[m.Lane]; lm.Active != 1 || lm.Queued != 2 || lm.Rejected != 1 {
+			t.Fatal("lane metrics not match ", lm)
+		}
+		close(release)
+		exec.Stop()
+		if m := exec.KeyMetrics(1); m.Active != 0 || m.Queued != 0 {
+			t.Fatal("metrics not match ", m)
+		}
+		if len(exec.AllKeyMetrics()) != 0 {
+			t.Fatal("key metrics must be removed")
+		}
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		exec := completable.NewKeyedExecutor(4, 16)
+		cf := completable.SupplyAsyncKeyed(exec, "acc", func() int {
+			return 1
+		})
+		cf = completable.ThenApplyAsyncKeyed(cf, exec, "acc", func(i int) int {
+			return i + 1
+		}, completable.WithName("inc"))
+		// 后续阶段继承绑定key的协程池
+		cf = cf.ThenApplyAsync(func(i int) int {
+			return i * 10
+		})
+		var v int
+		if err := cf.Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != 20 {
+			t.Fatal("expect 20 but get ", v)
+		}
+		exec.Stop()
+		lane := exec.KeyMetrics("acc").Lane
+		if c := exec.Metrics()[lane].Completed; c != 3 {
+			t.Fatal("expect 3 tasks on lane but get ", c)
+		}
+	})
+
+	t.Run("stop now", func(t *testing.T) {
+		exec := completable.NewKeyedExecutor(1, 4)
+		release := make(chan struct{})
+		started := make(chan struct{})
+		completable.RunAsyncKeyed(exec, "k", func() {
+			close(started)
+			<-release
+		})
+		<-started
+		cf := completable.RunAsyncKeyed(exec, "k", func() {}, completable.WithPanicPolicy(completable.PanicAsError))
+		if exec.KeyMetrics("k").Queued != 1 {
+			t.Fatal("expect 1 queued task")
+		}
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+		}()
+		exec.StopNow()
+		if err := cf.Get(nil); !errors.Is(err, completable.ErrPoolStopped) {
+			t.Fatal("expect ErrPoolStopped but get ", err)
+		}
+		if len(exec.AllKeyMetrics()) != 0 {
+			t.Fatal("key metrics must be removed")
+		}
+	})
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/lazycompletable"
+	"github.com/xfali/completable/queued"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 用户自定义实现，不实现Joinable
+type foreignStage struct {
+	completable.CompletionStage
+}
+
+func TestMixImplementations(t *testing.T) {
+	t.Run("combine lazy", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}).ThenCombine(lazycompletable.SupplyAsync(func() int {
+			return 2
+		}), func(a, b int) int {
+			return a + b
+		})
+		var v int
+		if err := cf.Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != 3 {
+			t.Fatal("expect 3 but get ", v)
+		}
+	})
+
+	t.Run("queued combine default", func(t *testing.T) {
+		cf := queued.SupplyAsync(func() int {
+			return 1
+		}).ThenCombine(completable.CompletedFuture(2), func(a, b int) int {
+			return a + b
+		})
+		var v int
+		if err := cf.Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != 3 {
+			t.Fatal("expect 3 but get ", v)
+		}
+	})
+
+	t.Run("either foreign", func(t *testing.T) {
+		other := foreignStage{completable.SupplyAsync(func() string {
+			return "foreign"
+		})}
+		cf := completable.SupplyAsync(func() string {
+			time.Sleep(time.Second)
+			return "default"
+		}).ApplyToEither(other, func(s string) string {
+			return s
+		})
+		var v string
+		if err := cf.Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != "foreign" {
+			t.Fatal("expect foreign but get ", v)
+		}
+	})
+
+	t.Run("allOf", func(t *testing.T) {
+		cfs := []completable.CompletionStage{
+			completable.CompletedFuture(1),
+			lazycompletable.SupplyAsync(func() int {
+				return 2
+			}),
+			queued.SupplyAsync(func() int {
+				return 3
+			}),
+			foreignStage{completable.CompletedFuture(4)},
+		}
+		if err := completable.AllOf(cfs...).Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := queued.AllOf(cfs...).Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := lazycompletable.AllOf(cfs...).Get(nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("anyOf foreign", func(t *testing.T) {
+		cf := completable.AnyOf(completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		}), foreignStage{completable.CompletedFuture(2)})
+		select {
+		case <-cf.Done():
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("anyOf not done")
+		}
+	})
+
+	t.Run("compose foreign", func(t *testing.T) {
+		cf := completable.CompletedFuture(1).ThenCompose(func(i int) completable.CompletionStage {
+			return foreignStage{completable.CompletedFuture(i + 1)}
+		})
+		var v int
+		if err := cf.Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != 2 {
+			t.Fatal("expect 2 but get ", v)
+		}
+	})
+
+	t.Run("foreign panic", func(t *testing.T) {
+		other := foreignStage{completable.SupplyAsync(func() int {
+			panic("foreign failed")
+		})}
+		cf := completable.CompletedFuture(1).ThenCombine(other, func(a, b int) int {
+			return a + b
+		})
+		if !cf.IsCompletedExceptionally() {
+			t.Fatal("must be completed exceptionally")
+		}
+	})
+
+	t.Run("cancel foreign", func(t *testing.T) {
+		origin := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		// 后续阶段全部取消时，桥接阶段取消外部阶段
+		cf := completable.CompletedFuture(1).ThenCombineAsync(foreignStage{origin}, func(a, b int) int {
+			return a + b
+		})
+		cf.CancelWithCause(errDisconnected)
+		select {
+		case <-origin.Done():
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("foreign stage not cancelled")
+		}
+		err := origin.Get(nil)
+		if !errors.Is(err, errDisconnected) {
+			t.Fatal("expect errDisconnected but get ", err)
+		}
+	})
+	t.Run("foreign engine executor", func(t *testing.T) {
+		exec := &countingExecutor{}
+		e := completable.NewEngine(completable.EngineExecutor(exec))
+		cf := e.CompletedFuture(1).ThenCombine(foreignStage{completable.CompletedFuture(2)}, func(a, b int) int {
+			return a + b
+		})
+		var v int
+		if err := cf.Get(&v); err != nil || v != 3 {
+			t.Fatal("expect 3 but get ", v, err)
+		}
+		if atomic.LoadInt32(&exec.runs) != 0 {
+			t.Fatal("waiting foreign stage must not occupy the engine executor, runs: ", exec.runs)
+		}
+	})
+
+	t.Run("foreign bounded pool", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 16)
+		e := completable.NewEngine(completable.EngineExecutor(pool))
+		p1, r1 := completable.NewPromise()
+		p2, r2 := completable.NewPromise()
+		add := func(a, b interface{}) int {
+			return a.(int) + b.(int)
+		}
+		cf := e.CompletedFuture(1).ThenCombineAsync(foreignStage{p1}, add).ThenCombineAsync(foreignStage{p2}, add)
+		// 等待外部阶段不占用唯一的工作协程，完成外部阶段的任务可以执行
+		e.RunAsync(func() {
+			r1.Resolve(2)
+			r2.Resolve(3)
+		})
+		var v int
+		if err := cf.Get(&v, time.Second); err != nil || v != 6 {
+			t.Fatal("expect 6 but get ", v, err)
+		}
+		pool.Stop()
+	})
+
+	t.Run("foreign stop waiting", func(t *testing.T) {
+		e := completable.NewEngine()
+		pending, _ := completable.NewPromise()
+		cf := e.CompletedFuture(1).ThenCombineAsync(foreignStage{pending}, func(a, b int) int {
+			return a + b
+		})
+		cf.Cancel()
+		select {
+		case <-pending.Done():
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("foreign stage must be cancelled with its only dependent")
+		}
+		other, _ := completable.NewPromise()
+		e.AnyOf(e.CompletedFuture(1), foreignStage{other})
+		if other.IsCancelled() {
+			t.Fatal("AnyOf must not cancel foreign stage")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		if err := e.Shutdown(ctx); err != nil {
+			t.Fatal("must stop waiting foreign stages: ", err)
+		}
+	})
+}
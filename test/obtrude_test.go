@@ -0,0 +1,149 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"github.com/xfali/completable"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestObtrude(t *testing.T) {
+	t.Run("value", func(t *testing.T) {
+		cf := completable.CompletedFuture(1)
+		before := cf.ThenApply(func(i int) int {
+			return i * 10
+		})
+		if err := cf.ObtrudeValue(2); err != nil {
+			t.Fatal(err)
+		}
+		ret := 0
+		if err := cf.Get(&ret); err != nil || ret != 2 {
+			t.Fatal("not match", err, ret)
+		}
+		if err := before.Get(&ret); err != nil || ret != 10 {
+			t.Fatal("dependent created before obtrude must keep old value", err, ret)
+		}
+		if err := cf.ThenApply(func(i int) int { return i * 10 }).Get(&ret); err != nil || ret != 20 {
+			t.Fatal("dependent created after obtrude must get new value", err, ret)
+		}
+		if err := cf.ObtrudeValue("error"); err == nil {
+			t.Fatal("type must not match")
+		}
+	})
+
+	t.Run("exception", func(t *testing.T) {
+		cf := completable.CompletedFuture(1)
+		if err := cf.ObtrudeException("error"); err != nil {
+			t.Fatal(err)
+		}
+		if cf.State() != completable.Failed {
+			t.Fatal("must be failed, got", cf.State())
+		}
+		ve, ok := cf.Result()
+		if !ok || ve.GetPanic().(string) != "error" {
+			t.Fatal("not match")
+		}
+		if err := cf.ObtrudeValue(3); err != nil {
+			t.Fatal(err)
+		}
+		if cf.State() != completable.Succeeded {
+			t.Fatal("must be succeeded, got", cf.State())
+		}
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(200 * time.Millisecond)
+			return 1
+		})
+		if err := cf.ObtrudeValue(2); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(300 * time.Millisecond)
+		ret := 0
+		if err := cf.Get(&ret); err != nil || ret != 2 {
+			t.Fatal("later completion must be ignored", err, ret)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		})
+		cf.Cancel()
+		if err := cf.ObtrudeValue(2); err != nil {
+			t.Fatal(err)
+		}
+		ret := 0
+		if err := cf.Get(&ret); err != nil || ret != 2 {
+			t.Fatal("not match", err, ret)
+		}
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		cf := completable.CompletedFuture(1)
+		view := completable.ReadOnly(cf)
+		if _, ok := view.ObtrudeValue(2).(*completable.ReadOnlyError); !ok {
+			t.Fatal("must be ReadOnlyError")
+		}
+		if err := cf.ObtrudeValue(2); err != nil {
+			t.Fatal(err)
+		}
+		ret := 0
+		if err := view.Get(&ret); err != nil || ret != 2 {
+			t.Fatal("view must observe obtruded value", err, ret)
+		}
+	})
+}
+
+func TestResolverReset(t *testing.T) {
+	cf, resolver := completable.NewCompletableFuture(reflect.TypeOf(0))
+	resolver.Resolve(1)
+	done := cf.Done()
+	if err := resolver.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if cf.State() != completable.Pending || cf.IsDone() {
+		t.Fatal("must be pending, got", cf.State())
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("Done channel obtained before reset must stay closed")
+	}
+	select {
+	case <-cf.Done():
+		t.Fatal("must not be done after reset")
+	default:
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		resolver.Resolve(2)
+	}()
+	ret := 0
+	if err := cf.Get(&ret); err != nil || ret != 2 {
+		t.Fatal("not match", err, ret)
+	}
+
+	resolver.Cancel()
+	if err := resolver.Reset(); err == nil {
+		t.Fatal("cancelled stage cannot be reset")
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/lazycompletable"
+	"github.com/xfali/completable/queued"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	t.Run("name and executor", func(t *testing.T) {
+		exec := &countingExecutor{}
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, completable.WithName("supply"), completable.WithExecutor(exec))
+		// 原有的协程池参数与选项可以混用
+		dependent := cf.ThenApplyAsync(func(i int) int {
+			return i + 1
+		}, exec, completable.WithName("apply"))
+		if err := dependent.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if completable.StageName(cf) != "supply" || completable.StageName(dependent) != "apply" {
+			t.Fatal("name not match")
+		}
+		if atomic.LoadInt32(&exec.runs) != 2 {
+			t.Fatal("expect 2 but get ", exec.runs)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		}, completable.WithTimeout(100*time.Millisecond))
+		err := cf.Get(nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expect DeadlineExceeded but get ", err)
+		}
+		if !cf.IsCancelled() {
+			t.Fatal("must be cancelled")
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(time.Second)
+			return 1
+		}).ThenApplyAsync(func(i int) int {
+			return i
+		}, completable.WithContext(ctx))
+		cancel(errDisconnected)
+		err := cf.Get(nil)
+		if !errors.Is(err, errDisconnected) {
+			t.Fatal("expect errDisconnected but get ", err)
+		}
+	})
+
+	t.Run("panic policy", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			panic(errDisconnected)
+		}, completable.WithPanicPolicy(completable.PanicAsError))
+		err := cf.Get(nil)
+		if !errors.Is(err, errDisconnected) {
+			t.Fatal("expect errDisconnected but get ", err)
+		}
+	})
+
+	t.Run("forward", func(t *testing.T) {
+		exec := &countingExecutor{}
+		cf := lazycompletable.SupplyAsync(func() int {
+			return 1
+		}, completable.WithExecutor(exec)).ThenApplyAsync(func(i int) int {
+			return i + 1
+		}, completable.WithExecutor(exec), completable.WithName("lazy"))
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(&exec.runs) != 2 {
+			t.Fatal("expect 2 but get ", exec.runs)
+		}
+
+		cf = queued.SupplyAsync(func() int {
+			return 1
+		}).ThenApplyAsync(func(i int) int {
+			time.Sleep(time.Second)
+			return i
+		}, completable.WithName("queued"), completable.WithTimeout(100*time.Millisecond))
+		err := cf.Get(nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expect DeadlineExceeded but get ", err)
+		}
+	})
+	t.Run("not executor", func(t *testing.T) {
+		e := completable.NewEngine()
+		for name, f := range map[string]func(){
+			"EngineExecutor": func() { completable.EngineExecutor(completable.WithName("pool")) },
+			"SetExecutor":    func() { e.SetExecutor(completable.WithName("pool")) },
+			"WithExecutor":   func() { completable.WithExecutor(completable.WithName("pool")) },
+			"Delayed":        func() { e.DelayedExecutor(time.Second, completable.WithName("pool")) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatal(name, " must reject Option as executor")
+					}
+				}()
+				f()
+			}()
+		}
+	})
+
+	t.Run("root stages", func(t *testing.T) {
+		exec := &countingExecutor{}
+		root := completable.CompletedFuture(1, completable.WithName("root"), completable.WithExecutor(exec))
+		if err := root.ThenApplyAsync(func(i int) int {
+			return i + 1
+		}).Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if completable.StageName(root) != "root" || atomic.LoadInt32(&exec.runs) != 1 {
+			t.Fatal("root options not applied", completable.StageName(root), exec.runs)
+		}
+
+		promise, _ := completable.NewPromise(completable.WithTimeout(50 * time.Millisecond))
+		if err := promise.Get(nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expect DeadlineExceeded but get ", err)
+		}
+		pending, _ := completable.NewCompletableFuture(nil)
+		all := completable.AllOfWithOptions([]completable.CompletionStage{pending}, completable.WithTimeout(50*time.Millisecond))
+		if err := all.Get(nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expect DeadlineExceeded but get ", err)
+		}
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(errDisconnected)
+		anyOf := completable.AnyOfWithOptions([]completable.CompletionStage{pending}, completable.WithContext(ctx))
+		if err := anyOf.Get(nil); !errors.Is(err, errDisconnected) {
+			t.Fatal("expect errDisconnected but get ", err)
+		}
+	})
+
+	t.Run("no watcher goroutine", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+		for i := 0; i < 100; i++ {
+			completable.NewPromise(completable.WithTimeout(time.Hour))
+		}
+		if delta := runtime.NumGoroutine() - before; delta >= 50 {
+			t.Fatal("options must not start a goroutine per stage, started: ", delta)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"errors"
+	"github.com/xfali/completable"
+	"log"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	t.Run("bounded", func(t *testing.T) {
+		pool := completable.NewWorkerPool(2, 4)
+		release := make(chan struct{})
+		started := make(chan struct{}, 2)
+		for i := 0; i < 2; i++ {
+			if err := pool.Run(func() {
+				started <- struct{}{}
+				<-release
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		<-started
+		<-started
+		for i := 0; i < 4; i++ {
+			if err := pool.Run(func() {}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := pool.Run(func() {}); !errors.Is(err, completable.ErrPoolFull) {
+			t.Fatal("expect ErrPoolFull but get ", err)
+		}
+		m := pool.Metrics()
+		if m.Workers != 2 || m.Active != 2 || m.Queued != 4 || m.Rejected != 1 {
+			t.Fatal("metrics not match ", m)
+		}
+		close(release)
+		pool.Stop()
+		m = pool.Metrics()
+		if m.Active != 0 || m.Queued != 0 || m.Completed != 6 {
+			t.Fatal("metrics not match ", m)
+		}
+		if err := pool.Run(func() {}); !errors.Is(err, completable.ErrPoolStopped) {
+			t.Fatal("expect ErrPoolStopped but get ", err)
+		}
+	})
+
+	t.Run("stages", func(t *testing.T) {
+		pool := completable.NewWorkerPool(4, 16)
+		defer pool.Stop()
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, pool).ThenApplyAsync(func(i int) int {
+			return i + 1
+		})
+		var v int
+		if err := cf.Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != 2 {
+			t.Fatal("expect 2 but get ", v)
+		}
+		if pool.Metrics().Completed != 2 {
+			t.Fatal("expect 2 completed but get ", pool.Metrics())
+		}
+	})
+
+	t.Run("panicked", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 1)
+		pool.Run(func() {
+			panic("task failed")
+		})
+		pool.Stop()
+		if m := pool.Metrics(); m.Panicked != 1 || m.Completed != 1 {
+			t.Fatal("metrics not match ", m)
+		}
+	})
+
+	t.Run("panic handler", func(t *testing.T) {
+		var value interface{}
+		var stack []byte
+		pool := completable.NewWorkerPool(1, 1, completable.PoolPanicHandler(func(v interface{}, s []byte) {
+			value, stack = v, s
+		}))
+		pool.Run(func() {
+			panic("task failed")
+		})
+		pool.Stop()
+		if value != "task failed" {
+			t.Fatal("expect task failed but get ", value)
+		}
+		if !strings.Contains(string(stack), "pool_test.go") {
+			t.Fatal("stack must contain the panic site ", string(stack))
+		}
+	})
+
+	t.Run("panic log", func(t *testing.T) {
+		logged := make(chan string, 1)
+		completable.SetLogPanicStacks(func(s []byte) {
+			if strings.Contains(string(s), "priority task failed") {
+				select {
+				case logged <- string(s):
+				default:
+				}
+			}
+		}, false)
+		defer completable.SetLogPanicStacks(func(s []byte) {
+			log.Print(string(s))
+		}, false)
+		pool := completable.NewPriorityExecutor(1, 1)
+		pool.Run(func() {
+			panic("priority task failed")
+		})
+		pool.Stop()
+		select {
+		case s := <-logged:
+			t.Log(s)
+		case <-time.After(time.Second):
+			t.Fatal("panic not reported")
+		}
+	})
+
+	t.Run("stop now", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 4)
+		var ran int32
+		started := make(chan struct{})
+		blocker := completable.RunAsync(func() {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+		}, pool)
+		cf := completable.RunAsync(func() {
+			atomic.StoreInt32(&ran, 1)
+		}, pool, completable.WithPanicPolicy(completable.PanicAsError))
+		<-started
+		pool.StopNow()
+		if err := blocker.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		err := cf.Get(nil)
+		if !errors.Is(err, completable.ErrPoolStopped) {
+			t.Fatal("expect ErrPoolStopped but get ", err)
+		}
+		if atomic.LoadInt32(&ran) != 0 {
+			t.Fatal("discarded task must not run")
+		}
+	})
+}
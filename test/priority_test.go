@@ -0,0 +1,226 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/executor"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录任务优先级的协程池
+type priorityRecorder struct {
+	*serialExecutor
+	lock       sync.Mutex
+	priorities []int
+}
+
+func (e *priorityRecorder) RunContext(ctx context.Context, task executor.Task) error {
+	p, ok := completable.TaskPriority(ctx)
+	if !ok {
+		p = -1
+	}
+	e.lock.Lock()
+	e.priorities = append(e.priorities, p)
+	e.lock.Unlock()
+	return e.Run(task)
+}
+
+func TestPriorityExecutor(t *testing.T) {
+	// 阻塞唯一的工作协程，之后提交的任务在队列中排序
+	block := func(t *testing.T, exec executor.Executor) chan struct{} {
+		release := make(chan struct{})
+		started := make(chan struct{})
+		if err := exec.Run(func() {
+			close(started)
+			<-release
+		}); err != nil {
+			t.Fatal(err)
+		}
+		<-started
+		return release
+	}
+
+	t.Run("order", func(t *testing.T) {
+		exec := completable.NewPriorityExecutor(1, 16, completable.PriorityAging(0))
+		release := block(t, exec)
+		lock := sync.Mutex{}
+		var got []int
+		record := func(i int) func() {
+			return func() {
+				lock.Lock()
+				defer lock.Unlock()
+				got = append(got, i)
+			}
+		}
+		completable.RunAsync(record(1), exec, completable.WithPriority(1))
+		completable.RunAsync(record(0), exec)
+		completable.RunAsync(record(10), exec.WithPriority(10))
+		completable.RunAsync(record(5), exec, completable.WithPriority(5))
+		// 阶段指定的优先级优先于视图
+		completable.RunAsync(record(7), exec.WithPriority(-1), completable.WithPriority(7))
+		completable.RunAsync(record(6), exec, completable.WithPriority(5), completable.WithPriority(6))
+		close(release)
+		exec.Stop()
+		expect := []int{10, 7, 6, 5, 1, 0}
+		if len(got) != len(expect) {
+			t.Fatal("expect ", expect, " but get ", got)
+		}
+		for i := range expect {
+			if got[i] != expect[i] {
+				t.Fatal("expect ", expect, " but get ", got)
+			}
+		}
+	})
+
+	t.Run("aging", func(t *testing.T) {
+		exec := completable.NewPriorityExecutor(1, 16, completable.PriorityAging(10*time.Millisecond))
+		release := block(t, exec)
+		var got []string
+		exec.Run(func() {
+			got = append(got, "low")
+		})
+		time.Sleep(100 * time.Millisecond)
+		exec.WithPriority(3).Run(func() {
+			got = append(got, "high")
+		})
+		close(release)
+		exec.Stop()
+		if len(got) != 2 || got[0] != "low" {
+			t.Fatal("aged task must run first ", got)
+		}
+	})
+
+	t.Run("inherit", func(t *testing.T) {
+		exec := &priorityRecorder{serialExecutor: newSerialExecutor()}
+		defer exec.Stop()
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, exec, completable.WithPriority(5)).ThenApplyAsync(func(i int) int {
+			return i + 1
+		}).ThenApply(func(i int) int {
+			return i + 1
+		}).ThenApplyAsync(func(i int) int {
+			return i + 1
+		})
+		cf = cf.ThenApplyAsync(func(i int) int {
+			return i + 1
+		}, completable.WithPriority(1))
+		cf = completable.ReadOnly(cf).ThenApplyAsync(func(i int) int {
+			return i + 1
+		})
+		var v int
+		if err := cf.Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != 6 {
+			t.Fatal("expect 6 but get ", v)
+		}
+		if err := completable.RunAsync(func() {}, exec).Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		exec.lock.Lock()
+		defer exec.lock.Unlock()
+		expect := []int{5, 5, 5, 1, 1, -1}
+		if len(exec.priorities) != len(expect) {
+			t.Fatal("expect ", expect, " but get ", exec.priorities)
+		}
+		for i, p := range expect {
+			if exec.priorities[i] != p {
+				t.Fatal("expect ", expect, " but get ", exec.priorities)
+			}
+		}
+	})
+
+	t.Run("discard oldest", func(t *testing.T) {
+		exec := completable.NewPriorityExecutor(1, 2,
+			completable.PriorityAging(0),
+			completable.PriorityRejectionPolicy(completable.DiscardOldestPolicy))
+		release := block(t, exec)
+		low := completable.RunAsync(func() {}, exec, completable.WithPriority(1))
+		high := completable.RunAsync(func() {}, exec, completable.WithPriority(9))
+		mid := completable.RunAsync(func() {}, exec, completable.WithPriority(5))
+		lowest := completable.RunAsync(func() {}, exec, completable.WithPriority(0))
+		if !low.IsCancelled() || !lowest.IsCancelled() {
+			t.Fatal("lowest priority tasks must be discarded")
+		}
+		close(release)
+		if err := high.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := mid.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		exec.Stop()
+		if m := exec.Metrics(); m.Rejected != 2 || m.Completed != 3 {
+			t.Fatal("metrics not match ", m)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		exec := completable.NewPriorityExecutor(1, 1)
+		release := block(t, exec)
+		if err := exec.Run(func() {}); err != nil {
+			t.Fatal(err)
+		}
+		if err := exec.Run(func() {}); !errors.Is(err, completable.ErrPoolFull) {
+			t.Fatal("expect ErrPoolFull but get ", err)
+		}
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+		}()
+		// 等待队列有空位后提交
+		cf := completable.RunAsync(func() {}, exec,
+			completable.WithRejectionPolicy(completable.BlockPolicy(0)),
+			completable.WithPanicPolicy(completable.PanicAsError))
+		if err := cf.Get(nil); err != nil {
+			t.Fatal(err)
+		}
+		exec.StopNow()
+		if err := exec.Run(func() {}); !errors.Is(err, completable.ErrPoolStopped) {
+			t.Fatal("expect ErrPoolStopped but get ", err)
+		}
+	})
+
+	t.Run("no queue", func(t *testing.T) {
+		exec := completable.NewPriorityExecutor(1, 0)
+		defer exec.Stop()
+		started := make(chan struct{})
+		release := make(chan struct{})
+		// 工作协程可能尚未空闲，等待其取走任务
+		completable.RunAsync(func() {
+			close(started)
+			<-release
+		}, exec, completable.WithRejectionPolicy(completable.BlockPolicy(0)))
+		<-started
+		if err := exec.Run(func() {}); !errors.Is(err, completable.ErrPoolFull) {
+			t.Fatal("expect ErrPoolFull but get ", err)
+		}
+		close(release)
+		cf := completable.RunAsync(func() {}, exec,
+			completable.WithRejectionPolicy(completable.BlockPolicy(0)),
+			completable.WithPanicPolicy(completable.PanicAsError))
+		if err := cf.Get(nil, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
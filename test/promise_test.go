@@ -0,0 +1,145 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"github.com/xfali/completable"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewCompletableFuture(t *testing.T) {
+	t.Run("resolve", func(t *testing.T) {
+		cf, resolver := completable.NewCompletableFuture(reflect.TypeOf(""))
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if err := resolver.Resolve("Hello world"); err != nil {
+				t.Log(err)
+			}
+		}()
+		ret := ""
+		if err := cf.ThenApply(func(s string) string {
+			return s + "!"
+		}).Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "Hello world!" {
+			t.Fatal("not match")
+		}
+		if err := resolver.Resolve("again"); err == nil {
+			t.Fatal("must be already completed")
+		}
+	})
+
+	t.Run("resolve type error", func(t *testing.T) {
+		_, resolver := completable.NewCompletableFuture(reflect.TypeOf(""))
+		if err := resolver.Resolve(1); err == nil {
+			t.Fatal("must not match")
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		cf, resolver := completable.NewCompletableFuture(reflect.TypeOf(0))
+		go resolver.Reject("error")
+		ret := 0
+		if err := cf.Exceptionally(func(o interface{}) int {
+			if o.(string) != "error" {
+				t.Fatal("not match")
+			}
+			return 10
+		}).Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 10 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		cf, resolver := completable.NewCompletableFuture(reflect.TypeOf(0))
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			resolver.Cancel()
+		}()
+		if err := cf.Get(nil); err == nil {
+			t.Fatal("must be cancelled")
+		}
+		if !cf.IsCancelled() {
+			t.Fatal("must be cancelled")
+		}
+	})
+}
+
+func TestNewPromise(t *testing.T) {
+	t.Run("resolve", func(t *testing.T) {
+		cf, resolver := completable.NewPromise()
+		go resolver.Resolve(1)
+		ret := 0
+		if err := cf.ThenApply(func(i int) int {
+			return i + 1
+		}).Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 2 {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("type not match", func(t *testing.T) {
+		cf, resolver := completable.NewPromise()
+		next := cf.ThenApplyAsync(func(s string) string {
+			return s
+		}, completable.WithPanicPolicy(completable.PanicAsError))
+		resolver.Resolve(1)
+		if err := next.Get(nil); err == nil {
+			t.Fatal("int must not match string")
+		}
+	})
+}
+
+func TestFromCallback(t *testing.T) {
+	t.Run("resolve", func(t *testing.T) {
+		cf := completable.FromCallback(func(resolve func(v interface{}), reject func(v interface{})) {
+			time.AfterFunc(100*time.Millisecond, func() {
+				resolve("Hello world")
+			})
+		})
+		ret := ""
+		if err := cf.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "Hello world" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		cf := completable.FromCallback(func(resolve func(v interface{}), reject func(v interface{})) {
+			panic("error")
+		})
+		ret := ""
+		if err := cf.Handle(func(o interface{}, p interface{}) string {
+			return p.(string)
+		}).Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "error" {
+			t.Fatal("not match")
+		}
+	})
+}
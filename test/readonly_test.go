@@ -0,0 +1,152 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/lazycompletable"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	t.Run("complete", func(t *testing.T) {
+		origin := completable.SupplyAsync(func() string {
+			time.Sleep(100 * time.Millisecond)
+			return "Hello"
+		})
+		cf := completable.ReadOnly(origin)
+		var readOnlyErr *completable.ReadOnlyError
+		if err := cf.Complete("complete"); !errors.As(err, &readOnlyErr) {
+			t.Fatal("expect ReadOnlyError, got", err)
+		}
+		if err := cf.CompleteExceptionally("error"); !errors.As(err, &readOnlyErr) {
+			t.Fatal("expect ReadOnlyError, got", err)
+		}
+		if cf.Cancel() {
+			t.Fatal("read-only stage cannot be cancelled")
+		}
+		ret := ""
+		cf.ThenApply(func(s string) string {
+			return s + " world"
+		}).Get(&ret)
+		if ret != "Hello world" {
+			t.Fatal("not match")
+		}
+		origin.Get(&ret)
+		if ret != "Hello" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("dependent cancel", func(t *testing.T) {
+		origin := completable.SupplyAsync(func() string {
+			time.Sleep(500 * time.Millisecond)
+			return "Hello"
+		})
+		cf := completable.ReadOnly(origin).ThenApplyAsync(func(s string) string {
+			return s + " world"
+		})
+		cf.Cancel()
+		if origin.IsCancelled() {
+			t.Fatal("origin must not be cancelled")
+		}
+		ret := ""
+		if err := origin.Get(&ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != "Hello" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("state follows origin", func(t *testing.T) {
+		origin, _ := completable.NewPromise()
+		view := completable.ReadOnly(origin)
+		// 从视图派生的阶段被取消时，视图及原阶段的状态不变
+		fromView := view.ThenApplyAsync(func(v interface{}) interface{} {
+			return v
+		})
+		fromView.Cancel()
+		if view.IsCancelled() || view.State() != origin.State() {
+			t.Fatal("view must follow origin ", view.State(), origin.State())
+		}
+		// 原阶段的另一个后续阶段被取消时，原阶段因引用计数被取消，视图随之取消
+		second := origin.ThenApplyAsync(func(v interface{}) interface{} {
+			return v
+		})
+		second.Cancel()
+		if !origin.IsCancelled() || !view.IsCancelled() {
+			t.Fatal("view must follow origin ", view.State(), origin.State())
+		}
+		if view.Cancel() {
+			t.Fatal("read-only view cannot be cancelled")
+		}
+	})
+
+	t.Run("lazy", func(t *testing.T) {
+		cf := completable.ReadOnly(lazycompletable.CompletedFuture("Hello"))
+		var readOnlyErr *completable.ReadOnlyError
+		if err := cf.Complete("complete"); !errors.As(err, &readOnlyErr) {
+			t.Fatal("expect ReadOnlyError, got", err)
+		}
+		ret := ""
+		completable.CompletedFuture("world").ThenCombine(cf, func(s1, s2 string) string {
+			return s2 + " " + s1
+		}).Get(&ret)
+		if ret != "Hello world" {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("join", func(t *testing.T) {
+		cf := completable.ReadOnly(lazycompletable.SupplyAsync(func() string {
+			return "Hello"
+		}))
+		joinable, ok := cf.(completable.Joinable)
+		if !ok {
+			t.Fatal("expect Joinable")
+		}
+		joined := joinable.JoinCompletionStage(context.Background())
+		var readOnlyErr *completable.ReadOnlyError
+		if err := joined.Complete("complete"); !errors.As(err, &readOnlyErr) {
+			t.Fatal("expect ReadOnlyError, got", err)
+		}
+		ret := ""
+		if err := cf.Get(&ret); err != nil || ret != "Hello" {
+			t.Fatal("expect Hello but get ", ret, err)
+		}
+	})
+}
+
+func TestSplitInterface(t *testing.T) {
+	var stage completable.Stage = completable.CompletedFuture(1)
+	var awaitable completable.Awaitable = stage.ThenApply(func(i int) int {
+		return i + 1
+	})
+	var completer completable.Completer = completable.ReadOnly(completable.CompletedFuture(1))
+	ret := 0
+	awaitable.Get(&ret)
+	if ret != 2 {
+		t.Fatal("not match")
+	}
+	if completer.Complete(3) == nil {
+		t.Fatal("must be read-only")
+	}
+}
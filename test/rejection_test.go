@@ -0,0 +1,197 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/executor"
+	"testing"
+	"time"
+)
+
+var errBusy = errors.New("busy")
+
+// 总是拒绝任务的协程池
+type rejectingExecutor struct{}
+
+func (e rejectingExecutor) Run(task executor.Task) error {
+	return errBusy
+}
+
+func (e rejectingExecutor) Stop() {}
+
+// 总是返回ErrPoolFull的协程池
+type fullExecutor struct{}
+
+func (e fullExecutor) Run(task executor.Task) error {
+	return completable.ErrPoolFull
+}
+
+func (e fullExecutor) Stop() {}
+
+// 占满工作协程及队列，返回释放函数
+func fillPool(t *testing.T, pool *completable.WorkerPool) (queued completable.CompletionStage, release func()) {
+	ch := make(chan struct{})
+	started := make(chan struct{})
+	completable.RunAsync(func() {
+		close(started)
+		<-ch
+	}, pool)
+	<-started
+	queued = completable.RunAsync(func() {}, pool)
+	if m := pool.Metrics(); m.Queued != 1 {
+		t.Fatal("pool not full ", m)
+	}
+	return queued, func() {
+		close(ch)
+	}
+}
+
+func TestRejectionPolicy(t *testing.T) {
+	asError := completable.WithPanicPolicy(completable.PanicAsError)
+
+	t.Run("abort", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, rejectingExecutor{}, asError)
+		err := cf.Get(nil)
+		if !errors.Is(err, completable.ErrRejected) || !errors.Is(err, errBusy) {
+			t.Fatal("expect ErrRejected but get ", err)
+		}
+		dependent := completable.CompletedFuture(1).ThenApplyAsync(func(i int) int {
+			return i
+		}, rejectingExecutor{}, asError)
+		if err := dependent.Get(nil); !errors.Is(err, completable.ErrRejected) {
+			t.Fatal("expect ErrRejected but get ", err)
+		}
+	})
+
+	t.Run("caller runs", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, rejectingExecutor{}, completable.WithRejectionPolicy(completable.CallerRunsPolicy))
+		if !cf.IsDone() {
+			t.Fatal("must run in caller")
+		}
+		var v int
+		if err := cf.Get(&v); err != nil || v != 1 {
+			t.Fatal("expect 1 but get ", v, err)
+		}
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, rejectingExecutor{}, completable.WithRejectionPolicy(completable.DiscardPolicy))
+		if !cf.IsCancelled() {
+			t.Fatal("must be cancelled")
+		}
+		if err := cf.Get(nil); !errors.Is(err, completable.ErrRejected) {
+			t.Fatal("expect ErrRejected but get ", err)
+		}
+	})
+
+	t.Run("block timeout", func(t *testing.T) {
+		now := time.Now()
+		cf := completable.RunAsync(func() {}, fullExecutor{}, asError,
+			completable.WithRejectionPolicy(completable.BlockPolicy(100*time.Millisecond)))
+		if time.Since(now) < 100*time.Millisecond {
+			t.Fatal("must block until timeout")
+		}
+		if err := cf.Get(nil); !errors.Is(err, completable.ErrRejected) {
+			t.Fatal("expect ErrRejected but get ", err)
+		}
+	})
+
+	t.Run("block permanent error", func(t *testing.T) {
+		now := time.Now()
+		cf := completable.RunAsync(func() {}, rejectingExecutor{}, asError,
+			completable.WithRejectionPolicy(completable.BlockPolicy(0)))
+		if time.Since(now) > time.Second {
+			t.Fatal("must not wait for permanent error")
+		}
+		if err := cf.Get(nil); !errors.Is(err, completable.ErrRejected) || !errors.Is(err, errBusy) {
+			t.Fatal("expect errBusy but get ", err)
+		}
+	})
+
+	t.Run("block cancel", func(t *testing.T) {
+		root, _ := completable.NewPromise()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			root.ThenRunAsync(func() {}, fullExecutor{}, asError,
+				completable.WithRejectionPolicy(completable.BlockPolicy(0)))
+		}()
+		time.Sleep(20 * time.Millisecond)
+		// 取消上游阶段时下一阶段随之取消
+		root.Cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("must stop waiting after cancel")
+		}
+	})
+
+	t.Run("pool discard oldest", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 1, completable.PoolRejectionPolicy(completable.DiscardOldestPolicy))
+		defer pool.Stop()
+		oldest, release := fillPool(t, pool)
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, pool)
+		if !oldest.IsCancelled() {
+			t.Fatal("oldest must be cancelled")
+		}
+		release()
+		var v int
+		if err := cf.Get(&v); err != nil || v != 1 {
+			t.Fatal("expect 1 but get ", v, err)
+		}
+	})
+
+	t.Run("pool block", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 1)
+		defer pool.Stop()
+		_, release := fillPool(t, pool)
+		time.AfterFunc(50*time.Millisecond, release)
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, pool, completable.WithRejectionPolicy(completable.BlockPolicy(time.Second)))
+		var v int
+		if err := cf.Get(&v); err != nil || v != 1 {
+			t.Fatal("expect 1 but get ", v, err)
+		}
+	})
+
+	t.Run("pool abort", func(t *testing.T) {
+		pool := completable.NewWorkerPool(1, 1)
+		defer pool.Stop()
+		_, release := fillPool(t, pool)
+		defer release()
+		cf := completable.SupplyAsync(func() int {
+			return 1
+		}, pool, asError)
+		if err := cf.Get(nil); !errors.Is(err, completable.ErrPoolFull) {
+			t.Fatal("expect ErrPoolFull but get ", err)
+		}
+		if pool.Metrics().Rejected != 1 {
+			t.Fatal("expect 1 rejected but get ", pool.Metrics())
+		}
+	})
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"github.com/xfali/completable"
+	"github.com/xfali/completable/lazycompletable"
+	"github.com/xfali/completable/queued"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	t.Run("succeeded", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(200 * time.Millisecond)
+			return 1
+		})
+		time.Sleep(100 * time.Millisecond)
+		if cf.State() != completable.Running {
+			t.Fatal("must be running, got", cf.State())
+		}
+		time.Sleep(200 * time.Millisecond)
+		if cf.State() != completable.Succeeded {
+			t.Fatal("must be succeeded, got", cf.State())
+		}
+		if !cf.IsDone() || cf.IsCancelled() || cf.IsCompletedExceptionally() {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		cf, resolver := completable.NewCompletableFuture(reflect.TypeOf(0))
+		if cf.State() != completable.Pending || cf.IsDone() {
+			t.Fatal("must be pending, got", cf.State())
+		}
+		resolver.Resolve(1)
+		if cf.State() != completable.Succeeded || !cf.IsDone() {
+			t.Fatal("must be succeeded, got", cf.State())
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			panic("error")
+		})
+		time.Sleep(100 * time.Millisecond)
+		if cf.State() != completable.Failed {
+			t.Fatal("must be failed, got", cf.State())
+		}
+		if !cf.IsDone() || cf.IsCancelled() || !cf.IsCompletedExceptionally() {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		cf := completable.SupplyAsync(func() int {
+			time.Sleep(200 * time.Millisecond)
+			return 1
+		})
+		if !cf.Cancel() {
+			t.Fatal("must be cancelled")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if cf.State() != completable.Cancelled {
+			t.Fatal("must be cancelled, got", cf.State())
+		}
+		if !cf.IsDone() || !cf.IsCancelled() || !cf.IsCompletedExceptionally() {
+			t.Fatal("not match")
+		}
+	})
+
+	t.Run("cancel after done", func(t *testing.T) {
+		cf := completable.CompletedFuture(1)
+		if cf.Cancel() {
+			t.Fatal("completed stage cannot be cancelled")
+		}
+		if cf.State() != completable.Succeeded {
+			t.Fatal("must be succeeded, got", cf.State())
+		}
+	})
+
+	t.Run("lazy", func(t *testing.T) {
+		cf := lazycompletable.SupplyAsync(func() int {
+			return 1
+		}).ThenApply(func(i int) int {
+			return i + 1
+		})
+		if cf.State() != completable.Pending {
+			t.Fatal("must be pending, got", cf.State())
+		}
+		cf.Get(nil)
+		if cf.State() != completable.Succeeded || !cf.IsDone() {
+			t.Fatal("must be succeeded, got", cf.State())
+		}
+	})
+
+	t.Run("queued", func(t *testing.T) {
+		cf := queued.SupplyAsync(func() int {
+			return 1
+		}).ThenApply(func(i int) int {
+			panic("error")
+		})
+		if cf.State() != completable.Failed || !cf.IsCompletedExceptionally() {
+			t.Fatal("must be failed, got", cf.State())
+		}
+	})
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2022 Xiongfa Li.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/xfali/completable"
+	"github.com/xfali/executor"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func seqFib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return seqFib(n-1) + seqFib(n-2)
+}
+
+// 由阶段递归构建的斐波那契数列，n小于threshold时直接计算
+// 子问题以任务的context创建，在exec中执行（工作窃取协程池中放入当前工作协程的队列），
+// 合并时等待两个子阶段，使用UnlimitedExecutor避免占用exec的工作协程
+func fibStage(ctx context.Context, n, threshold int, exec executor.Executor) completable.CompletionStage {
+	return completable.CompletedFuture(n, completable.WithContext(ctx)).ThenComposeAsync(func(ctx context.Context, n int) completable.CompletionStage {
+		if n < threshold {
+			return completable.CompletedFuture(seqFib(n))
+		}
+		return fibStage(ctx, n-1, threshold, exec).ThenCombineAsync(fibStage(ctx, n-2, threshold, exec), func(a, b int) int {
+			return a + b
+		}, completable.UnlimitedExecutor{})
+	}, exec)
+}
+
+func TestWorkStealingPool(t *testing.T) {
+	t.Run("fib", func(t *testing.T) {
+		pool := completable.NewWorkStealingPool(4)
+		defer pool.Stop()
+		var v int
+		if err := fibStage(context.Background(), 20, 10, pool).Get(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != seqFib(20) {
+			t.Fatal("expect ", seqFib(20), " but get ", v)
+		}
+		t.Log("stolen: ", pool.Stolen(), " metrics: ", pool.Metrics())
+	})
+
+	t.Run("local", func(t *testing.T) {
+		pool := completable.NewWorkStealingPool(2)
+		defer pool.Stop()
+		release := make(chan struct{})
+		done := make(chan struct{})
+		// 阻塞另一个工作协程，使工作协程中提交的任务留在自身队列
+		pool.Run(func() {
+			<-release
+		})
+		pool.Fork(context.Background(), func(ctx context.Context) {
+			for i := 0; i < 3; i++ {
+				pool.Fork(ctx, func(ctx context.Context) {})
+			}
+			// 未使用工作协程ctx提交的任务放入共享队列
+			pool.Run(func() {})
+			sizes := pool.QueueSizes()
+			if sizes[0]+sizes[1] != 3 || (sizes[0] != 3 && sizes[1] != 3) {
+				t.Error("tasks forked with worker ctx must be queued locally ", sizes)
+			}
+			close(release)
+			close(done)
+		})
+		<-done
+	})
+
+	t.Run("local stages", func(t *testing.T) {
+		pool := completable.NewWorkStealingPool(2)
+		defer pool.Stop()
+		release := make(chan struct{})
+		// 阻塞另一个工作协程，阶段的任务只能由补偿协程窃取
+		pool.Run(func() {
+			<-release
+		})
+		cf := completable.CompletedFuture(1).ThenComposeAsync(func(ctx context.Context, i int) completable.CompletionStage {
+			a := completable.SupplyAsync(func() int {
+				return i + 1
+			}, pool, completable.WithContext(ctx))
+			b := a.ThenApplyAsync(func(v int) int {
+				return v + 1
+			})
+			sizes := pool.QueueSizes()
+			if sizes[0]+sizes[1] != 2 || (sizes[0] != 2 && sizes[1] != 2) {
+				t.Error("stage tasks submitted in worker must be queued locally ", sizes)
+			}
+			close(release)
+			// 等待时由补偿协程从当前工作协程的队列窃取
+			return a.ThenCombine(b, func(x, y int) int {
+				return x + y
+			})
+		}, pool)
+		var v int
+		if err := cf.Get(&v, 5*time.Second); err != nil || v != 5 {
+			t.Fatal("expect 5 but get ", v, err)
+		}
+		if pool.Stolen() != 2 {
+			t.Fatal("expect 2 stolen tasks but get ", pool.Stolen())
+		}
+	})
+
+	t.Run("steal", func(t *testing.T) {
+		pool := completable.NewWorkStealingPool(4)
+		wg := sync.WaitGroup{}
+		wg.Add(64)
+		var count int32
+		done := make(chan struct{})
+		pool.Fork(context.Background(), func(ctx context.Context) {
+			// 所有任务都放入当前工作协程的队列，其他工作协程只能窃取
+			for i := 0; i < 64; i++ {
+				pool.RunContext(ctx, func() {
+					atomic.AddInt32(&count, 1)
+					wg.Done()
+				})
+			}
+			wg.Wait()
+			close(done)
+		})
+		<-done
+		pool.Stop()
+		if count != 64 {
+			t.Fatal("expect 64 but get ", count)
+		}
+		if pool.Stolen() == 0 {
+			t.Fatal("idle workers must steal tasks")
+		}
+		if m := pool.Metrics(); m.Completed != 65 || m.Queued != 0 {
+			t.Fatal("metrics not match ", m)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		pool := completable.NewWorkStealingPool(2)
+		var count int32
+		for i := 0; i < 100; i++ {
+			pool.Run(func() {
+				atomic.AddInt32(&count, 1)
+			})
+		}
+		pool.Stop()
+		if count != 100 {
+			t.Fatal("accepted tasks must run before stop returns, get ", count)
+		}
+		if err := pool.Run(func() {}); !errors.Is(err, completable.ErrPoolStopped) {
+			t.Fatal("expect ErrPoolStopped but get ", err)
+		}
+	})
+}
+
+func benchmarkFib(b *testing.B, exec executor.Executor) {
+	expect := seqFib(27)
+	for i := 0; i < b.N; i++ {
+		var v int
+		if err := fibStage(context.Background(), 27, 16, exec).Get(&v); err != nil {
+			b.Fatal(err)
+		}
+		if v != expect {
+			b.Fatal("expect ", expect, " but get ", v)
+		}
+	}
+}
+
+func BenchmarkFibWorkStealing(b *testing.B) {
+	pool := completable.NewWorkStealingPool(0)
+	defer pool.Stop()
+	benchmarkFib(b, pool)
+}
+
+func BenchmarkFibUnlimited(b *testing.B) {
+	benchmarkFib(b, completable.UnlimitedExecutor{})
+}
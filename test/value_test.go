@@ -415,6 +415,61 @@ func TestAnyOfValue(test *testing.T) {
 	})
 }
 
+// 接收到的值被放回channel，同一个ValueHandler的多个等待者都可以获得该值
+func TestValueMultipleWaiters(test *testing.T) {
+	value := "Hello world"
+	v := reflect.ValueOf(value)
+	t := reflect.TypeOf(value)
+
+	test.Run("get", func(test *testing.T) {
+		vh := completable.NewAsyncHandler(t)
+		results := make(chan string, 8)
+		for i := 0; i < 8; i++ {
+			go func() {
+				results <- vh.Get(context.Background()).GetValue().String()
+			}()
+		}
+		time.Sleep(100 * time.Millisecond)
+		testSet(vh, v, test)
+		for i := 0; i < 8; i++ {
+			if s := <-results; s != value {
+				test.Fatal("expect ", value, " but get ", s)
+			}
+		}
+	})
+
+	test.Run("mixed", func(test *testing.T) {
+		vh1 := completable.NewAsyncHandler(t)
+		vh2 := completable.NewAsyncHandler(t)
+		done := make(chan completable.ValueOrError, 4)
+		go func() {
+			done <- vh1.SelectValue(vh2, nil)
+		}()
+		go func() {
+			v1, _ := vh1.BothValue(vh2, context.Background())
+			done <- v1
+		}()
+		go func() {
+			done <- completable.AllOfValue(context.Background(), vh1, vh2)[0]
+		}()
+		go func() {
+			_, ve := completable.AnyOfValue(context.Background(), vh1, vh2)
+			done <- ve
+		}()
+		time.Sleep(100 * time.Millisecond)
+		testSet(vh1, v, test)
+		testSet(vh2, v, test)
+		for i := 0; i < 4; i++ {
+			if ve := <-done; !ve.HaveValue() || ve.GetValue().String() != value {
+				test.Fatal("waiter must get the value")
+			}
+		}
+		// 等待者都结束后channel中仍保留结果
+		testGet(vh1, nil, test)
+		testGet(vh2, nil, test)
+	})
+}
+
 func testGet(vh completable.ValueHandler, ctx context.Context, test *testing.T) {
 	ret := vh.Get(ctx)
 	if !ret.HaveValue() {
@@ -42,23 +42,24 @@ func selectChannels(ctx context.Context, vhs ...CompletionStage) (channels []cha
 		cs := c
 		channels[i] = ch
 
-		if joinable, ok := cs.(Joinable); ok {
-			go func(ep *error) {
-				origin := joinable.JoinCompletionStage(ctx)
-				// Get maybe panic, ignore it and return the CompletionStage
-				defer func(ep *error, ret CompletionStage) {
-					if o := recover(); o != nil {
-						if e, ok := o.(error); ok {
-							*ep = e
-						} else {
-							*ep = fmt.Errorf("AnyOf panic: %v . ", o)
-						}
+		go func(ep *error) {
+			origin := cs
+			if joinable, ok := cs.(Joinable); ok {
+				origin = joinable.JoinCompletionStage(ctx)
+			}
+			// Get maybe panic, ignore it and return the CompletionStage
+			defer func(ep *error, ret CompletionStage) {
+				if o := recover(); o != nil {
+					if e, ok := o.(error); ok {
+						*ep = e
+					} else {
+						*ep = fmt.Errorf("AnyOf panic: %v . ", o)
 					}
-					ch <- origin
-				}(ep, origin)
-				origin.Get(nil)
-			}(&err)
-		}
+				}
+				ch <- origin
+			}(ep, origin)
+			origin.Get(nil)
+		}(&err)
 
 	}
 	return channels, nil
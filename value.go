@@ -20,9 +20,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"reflect"
-	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
@@ -40,11 +39,12 @@ const (
 	valueHandlerError
 	valueHandlerPanic
 	valueHandlerUnknown
+	// 任务已开始执行，尚未设置结果
+	valueHandlerRunning
 )
 
 var (
-	doneError  = errors.New("Done. ")
-	gDoneError = newDone()
+	doneError = errors.New("Done. ")
 )
 
 type ValueOrError interface {
@@ -74,6 +74,9 @@ type ValueOrError interface {
 
 	// 是否被context控制提前结束
 	IsDone() bool
+
+	// 被取消时获得取消错误，否则返回nil
+	GetCancellation() *CancellationError
 }
 
 type ValueHandler interface {
@@ -103,6 +106,12 @@ type ValueHandler interface {
 	// 同时等待并返回两个ValueHandler返回的ValueOrError（线程安全）
 	// ctx：控制context
 	BothValue(other ValueHandler, ctx context.Context) (v1, v2 ValueOrError)
+
+	// 返回设置结果后关闭的channel，可被任意多个观察者等待（线程安全）
+	Done() <-chan struct{}
+
+	// 非阻塞获得ValueOrError，未设置结果时返回false（线程安全）
+	Result() (ValueOrError, bool)
 }
 
 type vOrErr struct {
@@ -116,6 +125,14 @@ func newDone() *vOrErr {
 	}
 }
 
+// 创建取消结果，取消原因为ctx的cause
+func newCancelled(ctx context.Context) *vOrErr {
+	return &vOrErr{
+		v:      newCancellationError(context.Cause(ctx), -1),
+		status: vOrErrDone,
+	}
+}
+
 func newPanic(o interface{}) *vOrErr {
 	return &vOrErr{
 		v:      o,
@@ -124,9 +141,31 @@ func newPanic(o interface{}) *vOrErr {
 }
 
 type defaultValueHandler struct {
-	t         reflect.Type
+	t reflect.Type
+	// 创建时类型未知（t为nil）时，结果设置前确定的类型
+	resolved  atomic.Value
 	valueChan chan ValueOrError
 	status    int32
+
+	// 设置结果后关闭，Reset后重新创建
+	done   chan struct{}
+	result atomic.Value
+	lock   sync.Mutex
+
+	// 所属引擎及设置结果后的通知，由阶段创建时绑定
+	engine *Engine
+	notify func(v ValueOrError)
+	// 设置结果后执行的回调，由onComplete注册，执行后清空
+	callbacks map[*completionCallback]struct{}
+}
+
+type completionCallback struct {
+	f func()
+}
+
+// atomic.Value要求存储相同的具体类型
+type resultHolder struct {
+	v ValueOrError
 }
 
 func (ve vOrErr) GetValue() reflect.Value {
@@ -182,11 +221,22 @@ func (ve vOrErr) IsDone() bool {
 	return ve.status == vOrErrDone
 }
 
+func (ve vOrErr) GetCancellation() *CancellationError {
+	if ve.status != vOrErrDone {
+		return nil
+	}
+	if err, ok := ve.v.(*CancellationError); ok {
+		return err
+	}
+	return newCancellationError(nil, -1)
+}
+
 func NewAsyncHandler(t reflect.Type) *defaultValueHandler {
 	return &defaultValueHandler{
 		t:         t,
 		valueChan: make(chan ValueOrError, 1),
 		status:    valueHandlerNone,
+		done:      make(chan struct{}),
 	}
 }
 
@@ -195,14 +245,15 @@ func NewSyncHandler(t reflect.Type) *defaultValueHandler {
 		t:         t,
 		valueChan: make(chan ValueOrError, 1),
 		status:    valueHandlerNone,
+		done:      make(chan struct{}),
 	}
 }
 
 func (vh *defaultValueHandler) SetValueOrError(v ValueOrError) error {
 	ve := v.(vOrErr)
-	if atomic.CompareAndSwapInt32(&vh.status, valueHandlerNone, convertStatus(ve.status)) {
+	if vh.finish(convertStatus(ve.status)) {
 		if len(vh.valueChan) == 0 {
-			vh.valueChan <- v
+			vh.put(v)
 			return nil
 		} else {
 			return errors.New("Already have a value. ")
@@ -213,15 +264,16 @@ func (vh *defaultValueHandler) SetValueOrError(v ValueOrError) error {
 }
 
 func (vh *defaultValueHandler) SetValue(v reflect.Value) error {
-	if v.Type() != vh.t {
-		return fmt.Errorf("Type not match. expect: %s get %s . ", vh.t.String(), v.Type().String())
+	vh.resolveType(v.Type())
+	if v.Type() != vh.Type() {
+		return fmt.Errorf("Type not match. expect: %s get %s . ", vh.Type().String(), v.Type().String())
 	}
-	if atomic.CompareAndSwapInt32(&vh.status, valueHandlerNone, valueHandlerNormal) {
+	if vh.finish(valueHandlerNormal) {
 		if len(vh.valueChan) == 0 {
-			vh.valueChan <- vOrErr{
+			vh.put(vOrErr{
 				v:      v,
 				status: vOrErrNormal,
-			}
+			})
 			return nil
 		} else {
 			return errors.New("Already have a value. ")
@@ -233,12 +285,12 @@ func (vh *defaultValueHandler) SetValue(v reflect.Value) error {
 }
 
 func (vh *defaultValueHandler) SetError(err error) {
-	if atomic.CompareAndSwapInt32(&vh.status, valueHandlerNone, valueHandlerError) {
+	if vh.finish(valueHandlerError) {
 		if len(vh.valueChan) == 0 {
-			vh.valueChan <- vOrErr{
+			vh.put(vOrErr{
 				v:      err,
 				status: vOrErrError,
-			}
+			})
 		} else {
 			panic("Already have a value")
 		}
@@ -248,15 +300,15 @@ func (vh *defaultValueHandler) SetError(err error) {
 }
 
 func (vh *defaultValueHandler) SetPanic(o interface{}) {
-	if atomic.CompareAndSwapInt32(&vh.status, valueHandlerNone, valueHandlerPanic) {
+	if vh.finish(valueHandlerPanic) {
 		if len(vh.valueChan) == 0 {
-			vh.valueChan <- vOrErr{
+			vh.put(vOrErr{
 				v: &panicMsg{
 					origin: o,
-					trace:  stacks(),
+					trace:  vh.getEngine().stacks(),
 				},
 				status: vOrErrPanic,
-			}
+			})
 		} else {
 			panic("Already have a value")
 		}
@@ -265,64 +317,275 @@ func (vh *defaultValueHandler) SetPanic(o interface{}) {
 	}
 }
 
+// 从未完成状态（None或Running）转换为结束状态，成功返回true
+func (vh *defaultValueHandler) finish(status int32) bool {
+	for {
+		cur := atomic.LoadInt32(&vh.status)
+		if cur != valueHandlerNone && cur != valueHandlerRunning {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&vh.status, cur, status) {
+			return true
+		}
+	}
+}
+
+// 标记任务开始执行
+func (vh *defaultValueHandler) setRunning() {
+	atomic.CompareAndSwapInt32(&vh.status, valueHandlerNone, valueHandlerRunning)
+}
+
+// 取消，如果未完成则设置为Done，成功返回true
+func (vh *defaultValueHandler) setCancel(err *CancellationError) bool {
+	if vh.finish(valueHandlerUnknown) {
+		vh.put(vOrErr{
+			v:      err,
+			status: vOrErrDone,
+		})
+		return true
+	}
+	return false
+}
+
+// 保存结果并通知所有等待者，替换channel中已有的值
+// 绑定所属引擎，只读视图等共享ValueHandler的阶段不重复绑定
+func (vh *defaultValueHandler) bind(e *Engine, stage CompletionStage) {
+	if vh.engine != nil {
+		return
+	}
+	vh.engine = e
+	if hook := e.hooks.OnComplete; hook != nil {
+		vh.notify = func(v ValueOrError) {
+			hook(stage, v)
+		}
+	}
+}
+
+func (vh *defaultValueHandler) getEngine() *Engine {
+	if vh.engine == nil {
+		return defaultEngine
+	}
+	return vh.engine
+}
+
+func (vh *defaultValueHandler) put(v ValueOrError) {
+	callbacks := vh.store(v)
+	if vh.notify != nil {
+		vh.notify(v)
+	}
+	for cb := range callbacks {
+		cb.f()
+	}
+}
+
+// 保存结果，返回需要执行的回调
+func (vh *defaultValueHandler) store(v ValueOrError) map[*completionCallback]struct{} {
+	vh.lock.Lock()
+	defer vh.lock.Unlock()
+
+	vh.result.Store(resultHolder{v: v})
+	for {
+		select {
+		case <-vh.valueChan:
+		default:
+		}
+		// 接收者可能同时放回旧值，放回失败时重试
+		select {
+		case vh.valueChan <- v:
+		default:
+			continue
+		}
+		break
+	}
+	select {
+	case <-vh.done:
+	default:
+		close(vh.done)
+	}
+	callbacks := vh.callbacks
+	vh.callbacks = nil
+	return callbacks
+}
+
+// 注册设置结果后执行的回调，已有结果时立即执行，不占用协程
+// 返回取消注册的函数，回调已执行或已取消时不做任何操作
+func (vh *defaultValueHandler) onComplete(f func()) (remove func()) {
+	vh.lock.Lock()
+	select {
+	case <-vh.done:
+		vh.lock.Unlock()
+		f()
+		return func() {}
+	default:
+	}
+	cb := &completionCallback{f: f}
+	if vh.callbacks == nil {
+		vh.callbacks = map[*completionCallback]struct{}{}
+	}
+	vh.callbacks[cb] = struct{}{}
+	vh.lock.Unlock()
+	return func() {
+		vh.lock.Lock()
+		defer vh.lock.Unlock()
+		delete(vh.callbacks, cb)
+	}
+}
+
+// 强制设置结果，无论是否已经结束
+func (vh *defaultValueHandler) obtrude(v vOrErr) {
+	atomic.StoreInt32(&vh.status, convertStatus(v.status))
+	vh.put(v)
+}
+
+// 重置为未设置结果的状态
+func (vh *defaultValueHandler) reset() {
+	vh.lock.Lock()
+	defer vh.lock.Unlock()
+
+	atomic.StoreInt32(&vh.status, valueHandlerNone)
+	vh.result.Store(resultHolder{})
+	select {
+	case <-vh.valueChan:
+	default:
+	}
+	select {
+	case <-vh.done:
+		vh.done = make(chan struct{})
+	default:
+	}
+}
+
+func (vh *defaultValueHandler) Done() <-chan struct{} {
+	vh.lock.Lock()
+	defer vh.lock.Unlock()
+	return vh.done
+}
+
+func (vh *defaultValueHandler) Result() (ValueOrError, bool) {
+	if h, ok := vh.result.Load().(resultHolder); ok && h.v != nil {
+		return h.v, true
+	}
+	return nil, false
+}
+
+func (vh *defaultValueHandler) getStatus() int32 {
+	return atomic.LoadInt32(&vh.status)
+}
+
+// 将接收到的值放回channel，使得其他等待者也可以获得该值
+// 结果被Obtrude替换时channel中已有新值，放弃放回旧值
+func (vh *defaultValueHandler) recv(v ValueOrError) ValueOrError {
+	select {
+	case vh.valueChan <- v:
+	default:
+	}
+	return v
+}
+
 func (vh *defaultValueHandler) Type() reflect.Type {
-	return vh.t
+	if vh.t != nil {
+		return vh.t
+	}
+	if t, ok := vh.resolved.Load().(reflect.Type); ok {
+		return t
+	}
+	return nil
+}
+
+// 创建时类型未知时确定类型，只有第一次设置生效
+func (vh *defaultValueHandler) resolveType(t reflect.Type) {
+	if vh.t == nil && t != nil {
+		vh.resolved.CompareAndSwap(nil, t)
+	}
 }
 
 func (vh *defaultValueHandler) Get(ctx context.Context) ValueOrError {
+	// 已有结果时优先返回结果，避免与已结束的ctx随机选择
+	if v, ok := vh.Result(); ok {
+		return v
+	}
+	end, err := beginBlocking(ctx, true, vh.Done())
+	if err != nil {
+		return newBlockingPanic(err)
+	}
+	defer end()
 	if ctx == nil {
-		return <-vh.valueChan
+		return vh.recv(<-vh.valueChan)
 	} else {
 		select {
 		case v := <-vh.valueChan:
-			return v
+			return vh.recv(v)
 		case <-ctx.Done():
-			return newDone()
+			return newCancelled(ctx)
 		}
 	}
 }
 
 func (vh *defaultValueHandler) SelectValue(ovh ValueHandler, ctx context.Context) ValueOrError {
 	other := ovh.(*defaultValueHandler)
+	_, ok1 := vh.Result()
+	_, ok2 := other.Result()
+	if !ok1 && !ok2 {
+		end, err := beginBlocking(ctx, false, vh.Done(), other.Done())
+		if err != nil {
+			return newBlockingPanic(err)
+		}
+		defer end()
+	}
 	if ctx == nil {
 		select {
 		case v := <-vh.valueChan:
-			return v
+			return vh.recv(v)
 		case v := <-other.valueChan:
-			return v
+			return other.recv(v)
 		}
 	} else {
 		select {
 		case v := <-vh.valueChan:
-			return v
+			return vh.recv(v)
 		case v := <-other.valueChan:
-			return v
+			return other.recv(v)
 		case <-ctx.Done():
-			return newDone()
+			return newCancelled(ctx)
 		}
 	}
 }
 
 func (vh *defaultValueHandler) BothValue(ovh ValueHandler, ctx context.Context) (v1, v2 ValueOrError) {
 	other := ovh.(*defaultValueHandler)
+	_, ok1 := vh.Result()
+	_, ok2 := other.Result()
+	if !ok1 || !ok2 {
+		end, err := beginBlocking(ctx, true, vh.Done(), other.Done())
+		if err != nil {
+			return newBlockingPanic(err), newBlockingPanic(err)
+		}
+		defer end()
+	}
 	if ctx == nil {
-		v1 = <-vh.valueChan
-		v2 = <-other.valueChan
+		v1 = vh.recv(<-vh.valueChan)
+		v2 = other.recv(<-other.valueChan)
 		return
 	} else {
 		b1, b2 := false, false
+		// 获得值后置为nil，不再从该channel接收
+		ch1, ch2 := vh.valueChan, other.valueChan
 		for {
 			select {
-			case v1 = <-vh.valueChan:
+			case v1 = <-ch1:
+				vh.recv(v1)
+				ch1 = nil
 				b1 = true
-			case v2 = <-other.valueChan:
+			case v2 = <-ch2:
+				other.recv(v2)
+				ch2 = nil
 				b2 = true
 			case <-ctx.Done():
 				if !b1 {
-					v1 = newDone()
+					v1 = newCancelled(ctx)
 				}
 				if !b2 {
-					v2 = newDone()
+					v2 = newCancelled(ctx)
 				}
 				return
 			}
@@ -338,18 +601,49 @@ func AllOfValue(ctx context.Context, vhs ...ValueHandler) []ValueOrError {
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	dones := make([]<-chan struct{}, 0, len(vhs))
+	for _, vh := range vhs {
+		if _, ok := vh.Result(); !ok {
+			dones = append(dones, vh.Done())
+		}
+	}
+	if len(dones) > 0 {
+		end, err := beginBlocking(ctx, true, dones...)
+		if err != nil {
+			for i := range ret {
+				ret[i] = newBlockingPanic(err)
+			}
+			return ret
+		}
+		defer end()
+	}
 	for i, vh := range vhs {
 		select {
 		case v := <-vh.(*defaultValueHandler).valueChan:
-			ret[i] = v
+			ret[i] = vh.(*defaultValueHandler).recv(v)
 		case <-ctx.Done():
-			ret[i] = newDone()
+			ret[i] = newCancelled(ctx)
 		}
 	}
 	return ret
 }
 
 func AnyOfValue(ctx context.Context, vhs ...ValueHandler) (int, ValueOrError) {
+	dones := make([]<-chan struct{}, len(vhs))
+	for i, vh := range vhs {
+		if _, ok := vh.Result(); ok {
+			dones = nil
+			break
+		}
+		dones[i] = vh.Done()
+	}
+	if len(dones) > 0 {
+		end, err := beginBlocking(ctx, false, dones...)
+		if err != nil {
+			return 0, newBlockingPanic(err)
+		}
+		defer end()
+	}
 	size := len(vhs)
 	if ctx != nil {
 		size++
@@ -369,9 +663,9 @@ func AnyOfValue(ctx context.Context, vhs ...ValueHandler) (int, ValueOrError) {
 	}
 	index, value, _ := reflect.Select(selectCases)
 	if index == len(vhs) {
-		return index, newDone()
+		return index, newCancelled(ctx)
 	}
-	return index, value.Interface().(ValueOrError)
+	return index, vhs[index].(*defaultValueHandler).recv(value.Interface().(ValueOrError))
 }
 
 var vOrErrMap = map[int32]int32{
@@ -391,35 +685,7 @@ type panicMsg struct {
 	trace  []byte
 }
 
-var (
-	panicPrinter = func(s []byte) {
-		log.Print(string(s))
-	}
-	allStacks = false
-)
-
+// 设置默认引擎的panic堆栈输出函数，f为nil时不记录堆栈，all为true时记录所有协程的堆栈
 func SetLogPanicStacks(f func(s []byte), all bool) {
-	panicPrinter = f
-	allStacks = all
-}
-
-func stacks() []byte {
-	if panicPrinter == nil {
-		return nil
-	}
-
-	n := 10240
-	if allStacks {
-		n = 102400
-	}
-	var trace []byte
-	var i uint8 = 0
-	for ; i < 5; i++ {
-		trace = make([]byte, n<<i)
-		nbytes := runtime.Stack(trace, allStacks)
-		if nbytes < len(trace) {
-			return trace[:nbytes]
-		}
-	}
-	return trace
+	defaultEngine.SetLogPanicStacks(f, all)
 }